@@ -0,0 +1,116 @@
+package sqlite3utils
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var numericPattern = regexp.MustCompile(`^\s*[+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d+)?\s*$`)
+
+// parseNumeric converts text that is a well-formed number to int64 or
+// float64.
+func parseNumeric(s string) (interface{}, bool) {
+	if !numericPattern.MatchString(s) {
+		return nil, false
+	}
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, true
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f, true
+}
+
+// applyAffinity converts a value the way SQLite does before storing it
+// in a column.
+// sqlite3/src/vdbe.c:applyAffinity
+func applyAffinity(v interface{}, affinity Affinity) interface{} {
+	switch affinity {
+	case AffinityBlob:
+		return v
+	case AffinityText:
+		switch x := v.(type) {
+		case int64:
+			return strconv.FormatInt(x, 10)
+		case float64:
+			return FormatReal(x, RealSQLite)
+		}
+		return v
+	}
+
+	if s, ok := v.(string); ok {
+		if n, ok := parseNumeric(s); ok {
+			v = n
+		}
+	}
+	switch x := v.(type) {
+	case int64:
+		if affinity == AffinityReal {
+			return float64(x)
+		}
+	case float64:
+		if affinity != AffinityReal && x == math.Trunc(x) && x >= -(1<<63) && x < 1<<63 {
+			return int64(x)
+		}
+	}
+	return v
+}
+
+// value returns the field as nil, int64, float64, string or []byte.
+func (d *Data) value(encoding int) interface{} {
+	switch {
+	case d.isNull():
+		return nil
+	case d.isInt():
+		return d.int64()
+	case d.isFloat():
+		return d.float64()
+	case d.isText():
+		return d.text(encoding)
+	}
+	return d.Bytes
+}
+
+// valueData is the reverse of value.
+func valueData(v interface{}, header *Header) *Data {
+	switch x := v.(type) {
+	case int64:
+		return smallIntData(x, header.schemaNumber)
+	case float64:
+		return realData(x)
+	case string:
+		return textData(x, header.encoding)
+	case []byte:
+		return blobData(x)
+	}
+	return nullData()
+}
+
+// withAffinity returns the field as read from a column of the given
+// affinity. SQLite applies affinity when storing values; on read it only
+// turns the integers that REAL columns store for values without a
+// fraction back into reals. Other fields are returned as they are.
+// sqlite3/src/vdbe.c:OP_RealAffinity
+func withAffinity(d *Data, affinity Affinity) *Data {
+	if affinity == AffinityReal && d.isInt() {
+		return realData(d.float64())
+	}
+	return d
+}
+
+// presentAffinity makes the REAL columns of every table give back as
+// reals the integers SQLite stores for REAL values without a fraction.
+func (s *Storage) presentAffinity() {
+	for _, schema := range s.Schemas {
+		table, ok := s.Tables[schema.Name]
+		if schema.Type != "table" || !ok || schema.WithoutRowid {
+			continue
+		}
+		table.present.affinities = make([]Affinity, len(schema.Columns))
+		for i, column := range schema.Columns {
+			table.present.affinities[i] = column.Affinity()
+		}
+	}
+}
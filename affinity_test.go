@@ -0,0 +1,80 @@
+package sqlite3utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyAffinity(t *testing.T) {
+	cases := []struct {
+		value    interface{}
+		affinity Affinity
+		expected interface{}
+	}{
+		{" 12 ", AffinityInteger, int64(12)},
+		{"1.5e1", AffinityNumeric, int64(15)},
+		{"1.25", AffinityNumeric, 1.25},
+		{"99999999999999999999", AffinityInteger, 1e20},
+		{"12abc", AffinityInteger, "12abc"},
+		{int64(3), AffinityReal, 3.0},
+		{"3", AffinityReal, 3.0},
+		{3.0, AffinityInteger, int64(3)},
+		{int64(7), AffinityText, "7"},
+		{100.0, AffinityText, "100.0"},
+		{"12", AffinityBlob, "12"},
+		{[]byte("12"), AffinityInteger, []byte("12")},
+		{nil, AffinityReal, nil},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, applyAffinity(c.value, c.affinity), "%#v", c.value)
+	}
+}
+
+func TestLoadAffinity(t *testing.T) {
+	filename := "/tmp/test_affinity.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"CREATE TABLE item(price real, code integer, label);",
+		"INSERT INTO item VALUES (3, 12, 5), (2.5, 13, \"x\");",
+		// declared types changed after the rows were stored
+		".dbconfig defensive off\nPRAGMA writable_schema=ON; UPDATE sqlite_master SET sql = \"CREATE TABLE item(price real, code text, label numeric)\" WHERE name = \"item\";",
+	})
+
+	execSQLite(filename, []string{"CREATE TABLE types AS SELECT typeof(price), typeof(code), typeof(label) FROM item;"})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	entries := storage.Tables["item"].Entries
+	for i, e := range storage.Tables["types"].Entries {
+		for j, d := range e.Datas {
+			assert.Equal(t, d.Value, entries[i].Datas[j].Type(), "row %d column %d", i, j)
+		}
+	}
+	assert.True(t, entries[0].Datas[0].isFloat())
+	assert.Equal(t, 3.0, entries[0].Datas[0].float64())
+	// affinity applies when storing, so typeof(code) is still integer
+	assert.Equal(t, "12", entries[0].Datas[1].Value)
+	assert.True(t, entries[0].Datas[1].isInt())
+	assert.True(t, entries[0].Datas[2].isInt())
+	assert.Equal(t, 2.5, entries[1].Datas[0].float64())
+	assert.Equal(t, "x", entries[1].Datas[2].Value)
+
+	storage, err = LoadWithOptions(filename, LoadOptions{Raw: true})
+	assert.Nil(t, err)
+	entries = storage.Tables["item"].Entries
+	assert.True(t, entries[0].Datas[0].isInt())
+	assert.True(t, entries[0].Datas[1].isInt())
+
+	// the typed accessors read either storage class
+	price := entries[0].Datas[0]
+	assert.Equal(t, "integer", price.Type())
+	assert.Equal(t, 3.0, price.Float64())
+	assert.Equal(t, int64(3), price.Int64())
+	assert.Equal(t, 2.5, entries[1].Datas[0].Float64())
+	assert.Equal(t, int64(2), entries[1].Datas[0].Int64())
+	assert.Equal(t, "text", entries[1].Datas[2].Type())
+	assert.Equal(t, 0.0, entries[1].Datas[2].Float64())
+
+	rmSQLite(filename)
+}
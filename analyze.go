@@ -0,0 +1,236 @@
+package sqlite3utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SpaceUsage is the space taken by a table or an index.
+type SpaceUsage struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"` // "table" or "index"
+	TableName string `json:"table_name"`
+
+	Pages         int   `json:"pages"` // b-tree and overflow pages
+	PagesByLevel  []int `json:"pages_by_level"`
+	InteriorPages int   `json:"interior_pages"`
+	LeafPages     int   `json:"leaf_pages"`
+	OverflowPages int   `json:"overflow_pages"`
+
+	Entries      int     `json:"entries"` // rows of a table, keys of an index
+	PayloadBytes int     `json:"payload_bytes"`
+	AvgPayload   float64 `json:"avg_payload"`
+	MaxPayload   int     `json:"max_payload"`
+
+	// UnusedBytes is the space of its pages holding nothing, including
+	// freeblocks and fragments.
+	UnusedBytes     int `json:"unused_bytes"`
+	FragmentedBytes int `json:"fragmented_bytes"`
+	// Fragmentation is the percentage of pages, in key order, that do
+	// not directly follow the previous page in the file.
+	Fragmentation float64 `json:"fragmentation"`
+}
+
+// SpaceReport is the space usage of a whole database.
+type SpaceReport struct {
+	PageSize      int           `json:"page_size"`
+	Pages         int           `json:"pages"`
+	FreelistPages int           `json:"freelist_pages"`
+	PtrmapPages   int           `json:"ptrmap_pages"`
+	Objects       []*SpaceUsage `json:"objects"`
+}
+
+type spaceWalker struct {
+	storage *Storage
+	usage   *SpaceUsage
+	visited map[int]bool
+	order   []int // pages in key order
+}
+
+// Analyze measures the space used by sqlite_master and every table and
+// index, like sqlite3_analyzer. Level 0 of PagesByLevel is the root.
+func Analyze(storage *Storage) *SpaceReport {
+	report := &SpaceReport{
+		PageSize: storage.Header.pageSize,
+		Pages:    len(storage.Pages),
+		Objects:  []*SpaceUsage{},
+	}
+
+	trunks, leaves, err := parseFreelist(storage.cnt, storage.Header)
+	if err == nil {
+		report.FreelistPages = len(trunks) + len(leaves)
+	}
+	for pageNum := 2; pageNum <= len(storage.Pages); pageNum++ {
+		if isPtrmapPage(pageNum, storage.Header) {
+			report.PtrmapPages++
+		}
+	}
+
+	master := sqliteMaster()
+	for _, schema := range append([]*Schema{master}, storage.Schemas...) {
+		if schema.RootPage < 1 || schema.RootPage > len(storage.Pages) {
+			continue
+		}
+		report.Objects = append(report.Objects, analyzeTree(storage, schema))
+	}
+	return report
+}
+
+func analyzeTree(storage *Storage, schema *Schema) *SpaceUsage {
+	w := &spaceWalker{
+		storage: storage,
+		usage: &SpaceUsage{
+			Name:         schema.Name,
+			Type:         schema.Type,
+			TableName:    schema.TableName,
+			PagesByLevel: []int{},
+		},
+		visited: map[int]bool{},
+		order:   []int{},
+	}
+	w.walk(schema.RootPage, 0)
+
+	u := w.usage
+	u.Pages = u.InteriorPages + u.LeafPages + u.OverflowPages
+	if u.Entries > 0 {
+		u.AvgPayload = float64(u.PayloadBytes) / float64(u.Entries)
+	}
+	if len(w.order) > 1 {
+		gaps := 0
+		for i := 1; i < len(w.order); i++ {
+			if w.order[i] != w.order[i-1]+1 {
+				gaps++
+			}
+		}
+		u.Fragmentation = 100 * float64(gaps) / float64(len(w.order)-1)
+	}
+	return u
+}
+
+// walk adds up a page and its subtrees, children in key order.
+func (w *spaceWalker) walk(pageNum, level int) {
+	storage := w.storage
+	header := storage.Header
+	if pageNum < 1 || pageNum > len(storage.Pages) || w.visited[pageNum] {
+		return
+	}
+	w.visited[pageNum] = true
+	page := storage.Pages[pageNum-1]
+	if page.pageType == 0 {
+		return
+	}
+
+	u := w.usage
+	for len(u.PagesByLevel) <= level {
+		u.PagesByLevel = append(u.PagesByLevel, 0)
+	}
+	u.PagesByLevel[level]++
+	w.order = append(w.order, pageNum)
+
+	interior := page.pageType == interiorTable || page.pageType == interiorIndex
+	used := 8 + 2*page.cellCount
+	if interior {
+		used += 4
+		u.InteriorPages++
+	} else {
+		u.LeafPages++
+	}
+	if pageNum == 1 {
+		used += 100
+	}
+	u.FragmentedBytes += page.fragments
+
+	for i := range page.cellPtrs {
+		c, err := parseCell(storage.cnt, page, header, i)
+		if err != nil {
+			continue
+		}
+		used += c.size
+		if page.pageType != interiorTable {
+			u.Entries++
+			u.PayloadBytes += c.payloadSize
+			if c.payloadSize > u.MaxPayload {
+				u.MaxPayload = c.payloadSize
+			}
+		}
+		if n := c.overflowPages(header); n > 0 {
+			w.walkOverflow(c.overflow, n, c.payloadSize-c.nLocal)
+		}
+		if interior {
+			w.walk(c.child, level+1)
+		}
+	}
+	if interior {
+		w.walk(page.rightPtr, level+1)
+	}
+
+	if used < header.usableSize {
+		u.UnusedBytes += header.usableSize - used
+	}
+}
+
+// walkOverflow counts the n pages of an overflow chain holding size
+// bytes.
+func (w *spaceWalker) walkOverflow(pageNum, n, size int) {
+	header := w.storage.Header
+	count := 0
+	for pageNum != 0 && count < n && !w.visited[pageNum] && pageNum <= len(w.storage.Pages) {
+		w.visited[pageNum] = true
+		count++
+		pageNum = fetchInt(w.storage.cnt, header.pageSize*(pageNum-1), 4)
+	}
+	w.usage.OverflowPages += count
+	if unused := (header.usableSize-4)*count - size; unused > 0 {
+		w.usage.UnusedBytes += unused
+	}
+}
+
+// WriteText writes the report in the format of sqlite3_analyzer.
+func (r *SpaceReport) WriteText(out io.Writer) error {
+	b := &strings.Builder{}
+	line := func(label string, value interface{}) {
+		if f, ok := value.(float64); ok {
+			value = fmt.Sprintf("%.1f", f)
+		}
+		fmt.Fprintf(b, "%s %s %v\n", label, strings.Repeat(".", 40-len(label)), value)
+	}
+
+	fmt.Fprintf(b, "/** Disk-Space Utilization Report **/\n\n")
+	line("Page size in bytes", r.PageSize)
+	line("Pages in the whole file", r.Pages)
+	line("Pages on the freelist", r.FreelistPages)
+	line("Pointer-map pages", r.PtrmapPages)
+
+	for _, u := range r.Objects {
+		title := "Table " + u.Name
+		if u.Type == "index" {
+			title = "Index " + u.Name + " of table " + u.TableName
+		}
+		fmt.Fprintf(b, "\n*** %s ***\n\n", title)
+		line("Number of entries", u.Entries)
+		line("Total pages used", u.Pages)
+		for level, n := range u.PagesByLevel {
+			line(fmt.Sprintf("Pages at level %d", level), n)
+		}
+		line("Primary pages used", u.InteriorPages+u.LeafPages)
+		line("Overflow pages used", u.OverflowPages)
+		line("Bytes of payload", u.PayloadBytes)
+		line("Average payload per entry", u.AvgPayload)
+		line("Maximum payload per entry", u.MaxPayload)
+		line("Unused bytes", u.UnusedBytes)
+		line("Fragmented bytes", u.FragmentedBytes)
+		line("Fragmentation", u.Fragmentation)
+	}
+
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// WriteJSON writes the report as JSON.
+func (r *SpaceReport) WriteJSON(out io.Writer) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
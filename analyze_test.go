@@ -0,0 +1,71 @@
+package sqlite3utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyze(t *testing.T) {
+	filename := "/tmp/test_analyze.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"PRAGMA page_size = 512; CREATE TABLE person(id integer primary key, name text);",
+		"CREATE INDEX person_name ON person(name); CREATE TABLE memo(body text);",
+		"INSERT INTO person WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 200) SELECT i, \"person-\" || i FROM n;",
+		"INSERT INTO memo VALUES (printf(\"%.1000c\", \"x\"));",
+		"INSERT INTO memo VALUES (printf(\"%.1000c\", \"y\")); DELETE FROM memo WHERE body LIKE \"y%\";",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	report := Analyze(storage)
+
+	assert.Equal(t, 512, report.PageSize)
+	assert.Equal(t, len(storage.Pages), report.Pages)
+	assert.Equal(t, 2, report.FreelistPages)
+
+	usage := map[string]*SpaceUsage{}
+	total := report.FreelistPages
+	for _, u := range report.Objects {
+		usage[u.Name] = u
+		total += u.Pages
+	}
+	assert.Equal(t, report.Pages, total)
+
+	person := usage["person"]
+	assert.Equal(t, "table", person.Type)
+	assert.Equal(t, 200, person.Entries)
+	assert.Equal(t, 2, len(person.PagesByLevel))
+	assert.Equal(t, 1, person.PagesByLevel[0])
+	assert.Equal(t, person.LeafPages, person.PagesByLevel[1])
+	assert.Equal(t, 0, person.OverflowPages)
+
+	index := usage["person_name"]
+	assert.Equal(t, "index", index.Type)
+	assert.Equal(t, "person", index.TableName)
+	assert.Equal(t, 200, index.Entries)
+
+	memo := usage["memo"]
+	assert.Equal(t, 1, memo.Entries)
+	assert.Equal(t, 1003, memo.PayloadBytes)
+	assert.Equal(t, 1003.0, memo.AvgPayload)
+	assert.Equal(t, 2, memo.OverflowPages)
+	assert.Equal(t, 3, memo.Pages)
+
+	text := &bytes.Buffer{}
+	assert.Nil(t, report.WriteText(text))
+	assert.True(t, strings.Contains(text.String(), "*** Index person_name of table person ***"))
+	assert.True(t, strings.Contains(text.String(), "Pages on the freelist ................... 2\n"))
+
+	out := &bytes.Buffer{}
+	assert.Nil(t, report.WriteJSON(out))
+	decoded := &SpaceReport{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), decoded))
+	assert.Equal(t, report, decoded)
+
+	rmSQLite(filename)
+}
@@ -0,0 +1,480 @@
+package sqlite3utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Blob returns all bytes of a BLOB or TEXT field, nil for other types.
+func (d *Data) Blob() []byte {
+	if d.isBlob() || d.isText() {
+		return d.Bytes
+	}
+	return nil
+}
+
+// payload gives access to the payload of a table b-tree cell, following
+// the overflow chain only as far as needed.
+type payload struct {
+	page   func(pageNum int) ([]byte, error) // image of a page
+	header *Header
+
+	leaf     int // page of the cell
+	local    int // offset of the local part on the page
+	nLocal   int
+	size     int
+	overflow []int // overflow pages found so far
+	next     int   // overflow page after the last one found
+}
+
+// tablePage returns the type, the cells and the right pointer of a page
+// of a table b-tree.
+func tablePage(page func(int) ([]byte, error), header *Header, num int) (int, []*cell, int, error) {
+	image, err := page(num)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	h := 0
+	if num == 1 {
+		h = 100
+	}
+	// the image is parsed as if it were the first page of a file
+	p := &Page{pageNum: 1, pageType: int(image[h])}
+	ptrs := h + 8
+	if p.pageType == interiorTable {
+		ptrs += 4
+	} else if p.pageType != leafTable {
+		return 0, nil, 0, corruptf(num, -1, -1, "page type %d is not a table b-tree page", p.pageType)
+	}
+	count := fetchInt(image, h+3, 2)
+	if ptrs+2*count > header.usableSize {
+		return 0, nil, 0, corruptf(num, -1, -1, "%d cells do not fit the page", count)
+	}
+	for i := 0; i < count; i++ {
+		p.cellPtrs = append(p.cellPtrs, fetchInt(image, ptrs+2*i, 2))
+	}
+
+	cells := []*cell{}
+	for i := range p.cellPtrs {
+		c, err := parseCell(image, p, header, i)
+		if err != nil {
+			return 0, nil, 0, corruptf(num, i, -1, "%s", err.(*CorruptError).Reason)
+		}
+		cells = append(cells, c)
+	}
+	rightPtr := 0
+	if p.pageType == interiorTable {
+		rightPtr = fetchInt(image, h+8, 4)
+	}
+	return p.pageType, cells, rightPtr, nil
+}
+
+func newPayload(page func(int) ([]byte, error), header *Header, num int, c *cell) *payload {
+	return &payload{
+		page:   page,
+		header: header,
+		leaf:   num,
+		local:  c.payload,
+		nLocal: c.nLocal,
+		size:   c.payloadSize,
+		next:   c.overflow,
+	}
+}
+
+// findPayload returns the payload of the row of a table b-tree.
+func findPayload(page func(int) ([]byte, error), header *Header, root int, rowid int64) (*payload, error) {
+	visited := map[int]bool{}
+	num := root
+	for {
+		if visited[num] {
+			return nil, corruptf(num, -1, -1, "b-tree loop")
+		}
+		visited[num] = true
+
+		pageType, cells, next, err := tablePage(page, header, num)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cells {
+			if pageType == interiorTable && rowid <= int64(c.rowid) {
+				next = c.child
+				break
+			}
+			if pageType == leafTable && rowid == int64(c.rowid) {
+				return newPayload(page, header, num, c), nil
+			}
+		}
+		if pageType == leafTable {
+			return nil, fmt.Errorf("No such rowid: %d", rowid)
+		}
+		num = next
+	}
+}
+
+// scanTable calls fn with the payload of every row of a table b-tree,
+// in rowid order.
+func scanTable(page func(int) ([]byte, error), header *Header, num int, visited map[int]bool, fn func(*payload) error) error {
+	if visited[num] {
+		return corruptf(num, -1, -1, "b-tree loop")
+	}
+	visited[num] = true
+
+	pageType, cells, rightPtr, err := tablePage(page, header, num)
+	if err != nil {
+		return err
+	}
+	for _, c := range cells {
+		if pageType == interiorTable {
+			err = scanTable(page, header, c.child, visited, fn)
+		} else {
+			err = fn(newPayload(page, header, num, c))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if pageType == interiorTable {
+		return scanTable(page, header, rightPtr, visited, fn)
+	}
+	return nil
+}
+
+// locate returns the page holding byte off of the payload, the offset of
+// the byte on the page and how many bytes of the payload follow it there.
+func (p *payload) locate(off int) (int, int, int, error) {
+	if off < p.nLocal {
+		return p.leaf, p.local + off, p.nLocal - off, nil
+	}
+
+	per := p.header.usableSize - 4
+	i := (off - p.nLocal) / per
+	for len(p.overflow) <= i {
+		if p.next == 0 || len(p.overflow) > p.size/per {
+			return 0, 0, 0, corruptf(p.leaf, -1, -1, "overflow chain ends after %d pages", len(p.overflow))
+		}
+		image, err := p.page(p.next)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		p.overflow = append(p.overflow, p.next)
+		p.next = fetchInt(image, 0, 4)
+	}
+
+	n := per - (off-p.nLocal)%per
+	if rest := p.size - off; n > rest {
+		n = rest
+	}
+	return p.overflow[i], 4 + (off-p.nLocal)%per, n, nil
+}
+
+// readAt fills b with the payload from off, which must lie inside it.
+func (p *payload) readAt(b []byte, off int) error {
+	for len(b) > 0 {
+		num, offset, n, err := p.locate(off)
+		if err != nil {
+			return err
+		}
+		image, err := p.page(num)
+		if err != nil {
+			return err
+		}
+		n = copy(b[:minInt(n, len(b))], image[offset:])
+		b = b[n:]
+		off += n
+	}
+	return nil
+}
+
+// field returns the offset and size of field i of the record in the
+// payload with its serial type, or serial type 0 if the record is
+// shorter.
+func (p *payload) field(i int) (int, int, int, error) {
+	buf := make([]byte, minInt(9, p.size))
+	if err := p.readAt(buf, 0); err != nil {
+		return 0, 0, 0, err
+	}
+	v, n, err := varintAt(buf, 0)
+	if err != nil || int(v) > p.size || int(v) < n {
+		return 0, 0, 0, corruptf(p.leaf, -1, -1, "bad record header size")
+	}
+	header := make([]byte, int(v))
+	if err := p.readAt(header, 0); err != nil {
+		return 0, 0, 0, err
+	}
+
+	offset := len(header)
+	for pos, j := n, 0; pos < len(header); j++ {
+		serialType, n, err := varintAt(header, pos)
+		if err != nil {
+			return 0, 0, 0, corruptf(p.leaf, -1, -1, "bad serial type in record header")
+		}
+		pos += n
+		size := serialTypeSize(int(serialType))
+		if j == i {
+			if offset+size > p.size {
+				return 0, 0, 0, corruptf(p.leaf, -1, -1, "field %d runs past the payload", i)
+			}
+			return offset, size, int(serialType), nil
+		}
+		offset += size
+	}
+	return offset, 0, 0, nil
+}
+
+// imagePage returns the pages of the database image cnt.
+func imagePage(cnt []byte, header *Header) func(int) ([]byte, error) {
+	pageSize := header.pageSize
+	return func(num int) ([]byte, error) {
+		if num < 1 || pageSize*num > len(cnt) {
+			return nil, corruptf(num, -1, -1, "page out of range")
+		}
+		return cnt[pageSize*(num-1) : pageSize*num], nil
+	}
+}
+
+// readSchemas reads sqlite_master, decoding its rows only, without
+// parsing the other pages of the database.
+func readSchemas(page func(int) ([]byte, error), header *Header) ([]*Schema, error) {
+	master := &Table{Entries: []*Entry{}}
+	err := scanTable(page, header, 1, map[int]bool{}, func(p *payload) error {
+		buf := make([]byte, p.size)
+		if err := p.readAt(buf, 0); err != nil {
+			return err
+		}
+		datas, err := decodeRecord(buf)
+		if err != nil {
+			return corruptf(p.leaf, -1, -1, "%s", reason(err))
+		}
+		master.Entries = append(master.Entries, &Entry{Datas: datas})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return makeSchemas(master, header.encoding), nil
+}
+
+// Blob reads a BLOB or TEXT field in place, like the incremental blob
+// I/O of SQLite, without copying the rest of the row. A Blob opened
+// through a Writer can also be written.
+type Blob struct {
+	payload *payload
+	start   int // offset of the field in the payload
+	size    int
+	offset  int64
+	writer  *Writer
+}
+
+// OpenBlob opens a BLOB or TEXT field of a row of a rowid table.
+func (s *Storage) OpenBlob(table, column string, rowid int64) (*Blob, error) {
+	schema, i, err := s.blobColumn(table, column)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := findPayload(imagePage(s.cnt, s.Header), s.Header, schema.RootPage, rowid)
+	if err != nil {
+		return nil, err
+	}
+	return openField(p, i)
+}
+
+// OpenBlobReader opens a BLOB or TEXT field of a row of the database
+// read from r, an *os.File for instance, without loading the database.
+// Only the pages of sqlite_master and of the path to the row are read
+// at first, and the overflow pages of the field as it is read, so that
+// large blobs are never held in memory. The WAL is not read: pages not
+// checkpointed yet are not seen.
+func OpenBlobReader(r io.ReaderAt, table, column string, rowid int64) (*Blob, error) {
+	buf := make([]byte, 100)
+	if n, err := r.ReadAt(buf, 0); n < len(buf) {
+		if err == io.EOF {
+			return nil, ErrNotSQLite
+		}
+		return nil, err
+	}
+	header, err := parseHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := header.pageSize
+	page := func(num int) ([]byte, error) {
+		if num < 1 {
+			return nil, corruptf(num, -1, -1, "page out of range")
+		}
+		image := make([]byte, pageSize)
+		n, err := r.ReadAt(image, int64(pageSize)*int64(num-1))
+		if n < pageSize {
+			if err == nil || err == io.EOF {
+				return nil, corruptf(num, -1, -1, "page out of range")
+			}
+			return nil, err
+		}
+		return image, nil
+	}
+
+	schemas, err := readSchemas(page, header)
+	if err != nil {
+		return nil, err
+	}
+	storage := &Storage{Header: header, Schemas: schemas}
+	schema, i, err := storage.blobColumn(table, column)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := findPayload(page, header, schema.RootPage, rowid)
+	if err != nil {
+		return nil, err
+	}
+	return openField(p, i)
+}
+
+// OpenBlob opens a BLOB or TEXT field for reading and writing. Writes go
+// to the pending transaction of the Writer.
+func (w *Writer) OpenBlob(table, column string, rowid int64) (*Blob, error) {
+	// the pages of the pending transaction, sqlite_master included
+	schemas, err := readSchemas(w.Page, w.header)
+	if err != nil {
+		return nil, err
+	}
+	storage := &Storage{Header: w.header, Schemas: schemas}
+	schema, i, err := storage.blobColumn(table, column)
+	if err != nil {
+		return nil, err
+	}
+	p, err := findPayload(w.Page, w.header, schema.RootPage, rowid)
+	if err != nil {
+		return nil, err
+	}
+	b, err := openField(p, i)
+	if err != nil {
+		return nil, err
+	}
+	b.writer = w
+	return b, nil
+}
+
+// blobColumn returns the table and the position of a column that can be
+// opened as a blob.
+func (s *Storage) blobColumn(table, column string) (*Schema, int, error) {
+	schema := s.Schema(table)
+	if schema == nil || schema.Type != "table" {
+		return nil, 0, fmt.Errorf("No such table: %s", table)
+	}
+	if schema.WithoutRowid {
+		return nil, 0, fmt.Errorf("Cannot open table without rowid: %s", table)
+	}
+	i := schema.Column(column)
+	if i < 0 {
+		return nil, 0, fmt.Errorf("No such column: %s", column)
+	}
+	if i == schema.RowidColumn() {
+		return nil, 0, fmt.Errorf("Cannot open value of type integer")
+	}
+	return schema, i, nil
+}
+
+func openField(p *payload, i int) (*Blob, error) {
+	start, size, serialType, err := p.field(i)
+	if err != nil {
+		return nil, err
+	}
+	d := &Data{SerialType: serialType}
+	if !d.isBlob() && !d.isText() {
+		return nil, fmt.Errorf("Cannot open value of type %s", d.Type())
+	}
+	return &Blob{payload: p, start: start, size: size}, nil
+}
+
+// Size returns the length of the field in bytes.
+func (b *Blob) Size() int64 {
+	return int64(b.size)
+}
+
+// ReadAt implements io.ReaderAt.
+func (b *Blob) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("Negative offset")
+	}
+	if off >= int64(b.size) {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if rest := int64(b.size) - off; int64(n) > rest {
+		n = int(rest)
+	}
+	if err := b.payload.readAt(p[:n], b.start+int(off)); err != nil {
+		return 0, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt, overwriting bytes of the field in the
+// pages holding them. The length of the field cannot change.
+func (b *Blob) WriteAt(p []byte, off int64) (int, error) {
+	if b.writer == nil {
+		return 0, errors.New("Blob is read-only")
+	}
+	if off < 0 || off+int64(len(p)) > int64(b.size) {
+		return 0, errors.New("Write past the end of the blob")
+	}
+
+	written := 0
+	for written < len(p) {
+		num, offset, n, err := b.payload.locate(b.start + int(off) + written)
+		if err != nil {
+			return written, err
+		}
+		image, err := b.writer.Page(num)
+		if err != nil {
+			return written, err
+		}
+		n = copy(image[offset:offset+n], p[written:])
+		if err := b.writer.WritePage(num, image); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Read implements io.Reader.
+func (b *Blob) Read(p []byte) (int, error) {
+	n, err := b.ReadAt(p, b.offset)
+	b.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (b *Blob) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		offset += int64(b.size)
+	default:
+		return 0, errors.New("Invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("Negative position")
+	}
+	b.offset = offset
+	return offset, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
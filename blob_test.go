@@ -0,0 +1,227 @@
+package sqlite3utils
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenBlob(t *testing.T) {
+	filename := "/tmp/test_blob.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"CREATE TABLE file(id integer primary key, name text, data blob, size integer);",
+		"INSERT INTO file VALUES (1, \"small\", CAST(\"hello\" AS BLOB), 5);",
+		"INSERT INTO file SELECT 2, \"large\", randomblob(3000000), 3000000;",
+		"INSERT INTO file VALUES (3, printf(\"%.5000c\", \"x\"), NULL, 0);",
+		"INSERT INTO file WITH RECURSIVE n(i) AS (SELECT 10 UNION ALL SELECT i + 1 FROM n WHERE i < 2000) SELECT i, \"f\" || i, CAST(\"data\" || i AS BLOB), 0 FROM n;",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	entries := storage.Tables["file"].Entries
+	expected := entries[1].Datas[2].Blob()
+	assert.Equal(t, 3000000, len(expected))
+
+	blob, err := storage.OpenBlob("file", "data", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3000000), blob.Size())
+	data, err := io.ReadAll(blob)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(expected, data))
+
+	_, err = blob.Seek(-10, io.SeekEnd)
+	assert.Nil(t, err)
+	buf := make([]byte, 20)
+	n, err := blob.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, expected[len(expected)-10:], buf[:n])
+
+	n, err = blob.ReadAt(buf, 1234567)
+	assert.Nil(t, err)
+	assert.Equal(t, expected[1234567:1234587], buf[:n])
+
+	blob, err = storage.OpenBlob("file", "data", 1)
+	assert.Nil(t, err)
+	data, _ = io.ReadAll(blob)
+	assert.Equal(t, "hello", string(data))
+
+	// text spilling to overflow pages
+	blob, err = storage.OpenBlob("file", "name", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5000), blob.Size())
+
+	// a row deeper in the b-tree
+	blob, err = storage.OpenBlob("file", "data", 1500)
+	assert.Nil(t, err)
+	data, _ = io.ReadAll(blob)
+	assert.Equal(t, "data1500", string(data))
+
+	_, err = storage.OpenBlob("file", "data", 3)
+	assert.EqualError(t, err, "Cannot open value of type null")
+	_, err = storage.OpenBlob("file", "size", 1)
+	assert.EqualError(t, err, "Cannot open value of type integer")
+	_, err = storage.OpenBlob("file", "data", 5000)
+	assert.EqualError(t, err, "No such rowid: 5000")
+	_, err = storage.OpenBlob("file", "nosuch", 1)
+	assert.NotNil(t, err)
+
+	rmSQLite(filename)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.ReaderAt
+	n int64
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestOpenBlobReader(t *testing.T) {
+	filename := "/tmp/test_blob_reader.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"CREATE TABLE file(id integer primary key, name text, data blob);",
+		"INSERT INTO file SELECT 1, \"large\", randomblob(3000000);",
+		"INSERT INTO file WITH RECURSIVE n(i) AS (SELECT 10 UNION ALL SELECT i + 1 FROM n WHERE i < 2000) SELECT i, \"f\" || i, CAST(\"data\" || i AS BLOB) FROM n;",
+		"CREATE TABLE other(x);",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	expected := storage.Tables["file"].Entries[0].Datas[2].Blob()
+
+	f, err := os.Open(filename)
+	assert.Nil(t, err)
+	defer f.Close()
+	r := &countingReader{r: f}
+
+	blob, err := OpenBlobReader(r, "file", "data", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3000000), blob.Size())
+	// only sqlite_master and the path to the row were read
+	assert.True(t, r.n < 10*int64(storage.Header.pageSize))
+
+	buf := make([]byte, 20)
+	n, err := blob.ReadAt(buf, 1234567)
+	assert.Nil(t, err)
+	assert.Equal(t, expected[1234567:1234587], buf[:n])
+	data, err := io.ReadAll(blob)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(expected, data))
+
+	blob, err = OpenBlobReader(f, "file", "data", 1500)
+	assert.Nil(t, err)
+	data, _ = io.ReadAll(blob)
+	assert.Equal(t, "data1500", string(data))
+
+	_, err = OpenBlobReader(f, "file", "data", 5000)
+	assert.EqualError(t, err, "No such rowid: 5000")
+	_, err = OpenBlobReader(f, "nosuch", "data", 1)
+	assert.NotNil(t, err)
+	_, err = OpenBlobReader(bytes.NewReader([]byte("not a database")), "file", "data", 1)
+	assert.Equal(t, ErrNotSQLite, err)
+
+	rmSQLite(filename)
+}
+
+func TestBlobWriteAt(t *testing.T) {
+	filename := "/tmp/test_blob_write.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"PRAGMA page_size = 512; CREATE TABLE file(id integer primary key, data blob, note text);",
+		"INSERT INTO file VALUES (1, zeroblob(5000), \"kept\");",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	_, err = storage.OpenBlob("file", "data", 1)
+	assert.Nil(t, err)
+
+	w, err := OpenWriter(filename, WriterOptions{})
+	assert.Nil(t, err)
+	blob, err := w.OpenBlob("file", "data", 1)
+	assert.Nil(t, err)
+
+	// spans the local payload and two overflow pages
+	patch := bytes.Repeat([]byte("abcdefghij"), 100)
+	n, err := blob.WriteAt(patch, 400)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, n)
+	_, err = blob.WriteAt([]byte("end"), 4997)
+	assert.Nil(t, err)
+	_, err = blob.WriteAt([]byte("past"), 4998)
+	assert.NotNil(t, err)
+
+	// reads see the pending writes
+	buf := make([]byte, 10)
+	_, err = blob.ReadAt(buf, 400)
+	assert.Nil(t, err)
+	assert.Equal(t, "abcdefghij", string(buf))
+	assert.Nil(t, w.Commit())
+
+	assert.Equal(t, "ok", integrityCheck(t, filename))
+	storage, err = Load(filename)
+	assert.Nil(t, err)
+	row := storage.Tables["file"].Entries[0]
+	expected := make([]byte, 5000)
+	copy(expected[400:], patch)
+	copy(expected[4997:], "end")
+	assert.Equal(t, expected, row.Datas[1].Blob())
+	assert.Equal(t, "kept", row.Datas[2].Value)
+
+	blob, err = storage.OpenBlob("file", "data", 1)
+	assert.Nil(t, err)
+	_, err = blob.WriteAt([]byte("x"), 0)
+	assert.EqualError(t, err, "Blob is read-only")
+
+	rmSQLite(filename)
+}
+
+func TestWriterOpenBlobPending(t *testing.T) {
+	filename := "/tmp/test_blob_pending.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"PRAGMA page_size = 512; CREATE TABLE file(id integer primary key, data blob);",
+		"INSERT INTO file VALUES (1, CAST(\"one\" AS BLOB));",
+	})
+
+	w, err := OpenWriter(filename, WriterOptions{})
+	assert.Nil(t, err)
+	im := &importer{w: w, t: newTreeWriter(w)}
+	schema, err := im.createTable("pending", "CREATE TABLE pending(data blob)")
+	assert.Nil(t, err)
+	large := bytes.Repeat([]byte("0123456789"), 300)
+	assert.Nil(t, im.t.appendRow(schema.RootPage, 1, encodeRecord([]*Data{blobData(large)})))
+	assert.Nil(t, im.t.appendRow(2, 2, encodeRecord([]*Data{nullData(), blobData([]byte("two"))})))
+
+	// the table and the rows of the pending transaction are seen
+	blob, err := w.OpenBlob("pending", "data", 1)
+	assert.Nil(t, err)
+	data, err := io.ReadAll(blob)
+	assert.Nil(t, err)
+	assert.Equal(t, large, data)
+	_, err = blob.WriteAt([]byte("x"), 2999)
+	assert.Nil(t, err)
+
+	blob, err = w.OpenBlob("file", "data", 2)
+	assert.Nil(t, err)
+	data, _ = io.ReadAll(blob)
+	assert.Equal(t, "two", string(data))
+	assert.Nil(t, w.Commit())
+
+	assert.Equal(t, "ok", integrityCheck(t, filename))
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, append(large[:2999:2999], 'x'), storage.Tables["pending"].Entries[0].Datas[0].Blob())
+
+	rmSQLite(filename)
+}
@@ -0,0 +1,562 @@
+package sqlite3utils
+
+import (
+	"encoding/binary"
+	"unicode/utf8"
+)
+
+/***********************************************************
+
+Deleted cells
+
+When a row is deleted its cell becomes a freeblock or, once the page
+is defragmented, part of the unallocated space. Pages left empty go to
+the freelist. None of these are erased unless secure_delete is on, so
+the record usually survives:
+
+	freeblock: the first 4 bytes of the cell (payload size, rowid, and
+	           often the record header size and the first serial type)
+	           are overwritten by the next-freeblock offset and the size
+	unallocated, freelist leaf: the cell is intact
+
+***********************************************************/
+
+// Regions of a page that carving scans.
+const (
+	RegionFreeblock   = "freeblock"
+	RegionUnallocated = "unallocated"
+	RegionFreelist    = "freelist"
+)
+
+// CarvedRow is a candidate for a deleted row found in space the database
+// no longer uses.
+type CarvedRow struct {
+	Table  string // table whose columns the record matches
+	Page   int
+	Offset int    // offset of the record in the file
+	Region string // RegionFreeblock, RegionUnallocated or RegionFreelist
+
+	// Rowid is only known when the cell header survived.
+	Rowid    uint64
+	HasRowid bool
+
+	// Datas lacks the columns added to the table after the row was
+	// written.
+	Datas []*Data
+
+	// Confidence is between 0 and 1: how likely the bytes really are a
+	// row of Table rather than a coincidence.
+	Confidence float64
+}
+
+type carver struct {
+	storage *Storage
+	header  *Header
+	bytes   []byte
+	tables  []*Schema
+	owners  map[int]*Schema // table owning each leaf page
+	rows    []*CarvedRow
+}
+
+// sqliteMaster describes the rows of sqlite_master, so that dropped
+// tables and indexes can be carved too.
+func sqliteMaster() *Schema {
+	schema := &Schema{
+		Type:      "table",
+		Name:      "sqlite_master",
+		TableName: "sqlite_master",
+		RootPage:  1,
+		SQL:       "CREATE TABLE sqlite_master(type text, name text, tbl_name text, rootpage integer, sql text)",
+	}
+	parseSchema(schema)
+	return schema
+}
+
+// Carve scans freeblocks, the unallocated space of b-tree pages and
+// freelist pages for records that match the columns of a table, and
+// returns them ordered by offset. Space on a page that belongs to a
+// table is only matched against that table.
+func Carve(storage *Storage) []*CarvedRow {
+	c := &carver{
+		storage: storage,
+		header:  storage.Header,
+		bytes:   storage.cnt,
+		tables:  []*Schema{sqliteMaster()},
+		owners:  map[int]*Schema{},
+		rows:    []*CarvedRow{},
+	}
+	for _, schema := range storage.Schemas {
+		if schema.Type == "table" && len(schema.Columns) > 0 {
+			c.tables = append(c.tables, schema)
+		}
+	}
+
+	for _, schema := range c.tables {
+		if schema.RootPage < 1 || schema.RootPage > len(storage.Pages) {
+			continue
+		}
+		pageType := leafTable
+		if schema.WithoutRowid {
+			pageType = leafIndex
+		}
+		for _, page := range walkPage(storage.Pages[schema.RootPage-1], pageType) {
+			c.owners[page.pageNum] = schema
+		}
+	}
+
+	trunk := map[int]bool{}
+	leaf := map[int]bool{}
+	trunks, leaves, err := parseFreelist(c.bytes, c.header)
+	if err == nil {
+		for _, pageNum := range trunks {
+			trunk[pageNum] = true
+		}
+		for _, pageNum := range leaves {
+			leaf[pageNum] = true
+		}
+	}
+
+	for _, page := range storage.Pages {
+		offset := c.header.pageSize * (page.pageNum - 1)
+		end := offset + c.header.usableSize
+		if trunk[page.pageNum] {
+			// the leaf page numbers take the start of a trunk page
+			offset += 8 + 4*fetchInt(c.bytes, offset+4, 4)
+		}
+		if trunk[page.pageNum] || leaf[page.pageNum] {
+			c.scan(page.pageNum, offset, end, RegionFreelist, nil)
+		} else if page.pageType != 0 {
+			c.carvePage(page)
+		}
+	}
+	return c.rows
+}
+
+// carvePage scans the unallocated space and the freeblocks of a b-tree
+// page.
+func (c *carver) carvePage(page *Page) {
+	base := c.header.pageSize * (page.pageNum - 1)
+	end := base + c.header.usableSize
+	offset := base
+	if page.pageNum == 1 {
+		offset += 100
+	}
+	offset += 8
+	if page.pageType == interiorIndex || page.pageType == interiorTable {
+		offset += 4
+	}
+	offset += 2 * page.cellCount
+
+	owner := c.owners[page.pageNum]
+	contentStart := base + page.startCellPtr
+	if contentStart > end {
+		contentStart = end
+	}
+	c.scan(page.pageNum, offset, contentStart, RegionUnallocated, owner)
+
+	// freeblocks are kept in increasing order of offset
+	next := page.freeBlock
+	for next != 0 {
+		block := base + next
+		size := fetchInt(c.bytes, block+2, 2)
+		if next < contentStart-base || size < 4 || block+size > end {
+			return
+		}
+		c.carveFreeblock(page.pageNum, block, size, owner)
+
+		following := fetchInt(c.bytes, block, 2)
+		if following != 0 && following < next+size {
+			return
+		}
+		next = following
+	}
+}
+
+// carveFreeblock first tries to restore the cell that starts the
+// freeblock, whose header was overwritten, then scans the rest.
+func (c *carver) carveFreeblock(pageNum, block, size int, owner *Schema) {
+	start := block + 4
+	end := block + size
+
+	var best *CarvedRow
+	for _, schema := range c.candidates(owner) {
+		row := c.matchClobbered(pageNum, start, end, schema)
+		if row != nil && (best == nil || row.Confidence > best.Confidence) {
+			best = row
+		}
+	}
+	if best != nil {
+		c.rows = append(c.rows, best)
+		start = best.Offset + c.recordSize(best.Datas)
+	}
+	c.scan(pageNum, start, end, RegionFreeblock, owner)
+}
+
+func (c *carver) candidates(owner *Schema) []*Schema {
+	if owner != nil {
+		return []*Schema{owner}
+	}
+	return c.tables
+}
+
+// scan looks for records at every offset between start and end.
+func (c *carver) scan(pageNum, start, end int, region string, owner *Schema) {
+	if end > len(c.bytes) {
+		end = len(c.bytes)
+	}
+	for offset := start; offset < end; {
+		var best *CarvedRow
+		for _, schema := range c.candidates(owner) {
+			row := c.match(pageNum, offset, start, end, schema)
+			if row != nil && (best == nil || row.Confidence > best.Confidence) {
+				best = row
+			}
+		}
+		if best == nil {
+			offset++
+			continue
+		}
+		best.Region = region
+		c.rows = append(c.rows, best)
+		offset += c.recordSize(best.Datas)
+	}
+}
+
+// match decodes an intact record at offset and looks for the cell
+// header in front of it, not before start.
+func (c *carver) match(pageNum, offset, start, end int, schema *Schema) *CarvedRow {
+	bytes := c.bytes[offset:end]
+	headerSize, n, err := varintAt(bytes, 0)
+	if err != nil || int(headerSize) <= n || int(headerSize) > len(bytes) {
+		return nil
+	}
+
+	types := []int{}
+	for pos := n; pos < int(headerSize); {
+		v, m, err := varintAt(bytes[:headerSize], pos)
+		if err != nil || len(types) == len(schema.Columns) {
+			return nil
+		}
+		types = append(types, int(v))
+		pos += m
+	}
+	if !addedLater(schema, len(types)) {
+		return nil
+	}
+
+	datas, ok := decodeFields(bytes[headerSize:], types)
+	if !ok {
+		return nil
+	}
+	confidence, ok := plausible(schema, datas, c.header.encoding)
+	if !ok {
+		return nil
+	}
+
+	row := &CarvedRow{
+		Table:      schema.Name,
+		Page:       pageNum,
+		Offset:     offset,
+		Datas:      datas,
+		Confidence: 0.6 + 0.2*confidence,
+	}
+	if len(types) < len(schema.Columns) {
+		// a shorter record matches more bytes by chance
+		row.Confidence -= 0.2
+	}
+	payloadSize := int(headerSize) + dataSize(datas)
+	if rowid, ok := c.cellHeader(offset, start, payloadSize, !schema.WithoutRowid); ok {
+		row.Rowid = rowid
+		row.HasRowid = !schema.WithoutRowid
+		row.Confidence += 0.2
+	}
+	return row
+}
+
+// addedLater tells whether a record of n fields can be a row of the
+// table: all the columns, or a row written before ALTER TABLE ADD COLUMN
+// added the others, which cannot be PRIMARY KEY nor NOT NULL without a
+// DEFAULT. The columns of sqlite_master never change.
+func addedLater(schema *Schema, n int) bool {
+	if n < 1 || n > len(schema.Columns) || n < len(schema.Columns) && schema.Name == "sqlite_master" {
+		return false
+	}
+	for _, column := range schema.Columns[n:] {
+		if column.PrimaryKey || column.NotNull && column.Default == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// cellHeader finds the payload size, and the rowid of a table cell,
+// right before the record at offset.
+func (c *carver) cellHeader(offset, start, payloadSize int, hasRowid bool) (uint64, bool) {
+	maxRowidLen := 0
+	if hasRowid {
+		maxRowidLen = 9
+	}
+	for rowidLen := 0; rowidLen <= maxRowidLen; rowidLen++ {
+		if hasRowid && rowidLen == 0 {
+			continue
+		}
+		for sizeLen := 1; sizeLen <= 9; sizeLen++ {
+			pos := offset - rowidLen - sizeLen
+			if pos < start {
+				break
+			}
+			v, n, err := varintAt(c.bytes[:offset], pos)
+			if err != nil || n != sizeLen || int(v) != payloadSize {
+				continue
+			}
+			if !hasRowid {
+				return 0, true
+			}
+			rowid, m, err := varintAt(c.bytes[:offset], pos+n)
+			if err == nil && m == rowidLen {
+				return rowid, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// matchClobbered restores a cell whose first 4 bytes were overwritten by
+// a freeblock header. With a 1-byte payload size, rowid and record header
+// size, the serial type of the first column is lost too; its size is
+// then whatever is left of the freeblock. As the record header size is
+// lost, records lacking columns added later are tried too.
+func (c *carver) matchClobbered(pageNum, start, end int, schema *Schema) *CarvedRow {
+	var best *CarvedRow
+	for fields := len(schema.Columns); fields >= 1; fields-- {
+		if !addedLater(schema, fields) {
+			continue
+		}
+		row := c.matchClobberedFields(pageNum, start, end, schema, fields)
+		if row != nil && fields < len(schema.Columns) {
+			row.Confidence -= 0.2
+		}
+		if row != nil && (best == nil || row.Confidence > best.Confidence) {
+			best = row
+		}
+	}
+	return best
+}
+
+// matchClobberedFields restores a clobbered record of the given number
+// of fields.
+func (c *carver) matchClobberedFields(pageNum, start, end int, schema *Schema, fields int) *CarvedRow {
+	var best *CarvedRow
+	for lost := 0; lost <= 1 && lost <= fields-1; lost++ {
+		bytes := c.bytes[start:end]
+
+		types := make([]int, lost)
+		pos := 0
+		for len(types) < fields {
+			v, n, err := varintAt(bytes, pos)
+			if err != nil {
+				break
+			}
+			types = append(types, int(v))
+			pos += n
+		}
+		if len(types) < fields {
+			continue
+		}
+
+		if lost == 1 {
+			size := end - start - pos
+			for _, t := range types[1:] {
+				size -= serialTypeSize(t)
+			}
+			types[0] = guessSerialType(size, schema.Columns[0])
+			if types[0] < 0 {
+				continue
+			}
+		}
+
+		datas, ok := decodeFields(bytes[pos:], types)
+		if !ok {
+			continue
+		}
+		confidence, ok := plausible(schema, datas, c.header.encoding)
+		if !ok {
+			continue
+		}
+
+		row := &CarvedRow{
+			Table:      schema.Name,
+			Page:       pageNum,
+			Offset:     start + pos - c.headerSize(types),
+			Region:     RegionFreeblock,
+			Datas:      datas,
+			Confidence: 0.3 + 0.2*confidence,
+		}
+		if best == nil || row.Confidence > best.Confidence {
+			best = row
+		}
+	}
+	return best
+}
+
+// guessSerialType returns the serial type of a field of size bytes, or
+// -1 if there is none.
+func guessSerialType(size int, column *Column) int {
+	switch size {
+	case 0:
+		return 0
+	case 1, 2, 3, 4:
+		return size
+	case 6:
+		return 5
+	case 8:
+		if column.Affinity() == AffinityReal {
+			return 7
+		}
+		return 6
+	}
+	if size < 0 {
+		return -1
+	}
+	if column.Affinity() == AffinityBlob {
+		return 12 + 2*size
+	}
+	return 13 + 2*size
+}
+
+// headerSize returns the size of a record header for the serial types.
+func (c *carver) headerSize(types []int) int {
+	size := 0
+	for _, t := range types {
+		size += len(encodeVarint(uint64(t)))
+	}
+	size++
+	if size > 127 {
+		size++
+	}
+	return size
+}
+
+func (c *carver) recordSize(datas []*Data) int {
+	types := []int{}
+	for _, d := range datas {
+		types = append(types, d.SerialType)
+	}
+	return c.headerSize(types) + dataSize(datas)
+}
+
+// serialTypeSize returns the size of a field of the serial type.
+func serialTypeSize(t int) int {
+	switch {
+	case t >= 12:
+		return (t - 12) / 2
+	case t == 7:
+		return 8
+	case t >= 1 && t <= 6:
+		return []int{1, 2, 3, 4, 6, 8}[t-1]
+	}
+	return 0
+}
+
+func dataSize(datas []*Data) int {
+	size := 0
+	for _, d := range datas {
+		size += len(d.Bytes)
+	}
+	return size
+}
+
+// decodeFields decodes the fields of the serial types from the body of
+// a record.
+func decodeFields(body []byte, types []int) ([]*Data, bool) {
+	datas := []*Data{}
+	offset := 0
+	for _, t := range types {
+		if t == 10 || t == 11 {
+			return nil, false
+		}
+		d, err := takeData(fetch(body, offset, 0), t)
+		if err != nil {
+			return nil, false
+		}
+		datas = append(datas, d)
+		offset += len(d.Bytes)
+	}
+	return datas, true
+}
+
+// plausible tells whether the fields can be a row of the table, and
+// which fraction of them agrees with the column affinities.
+func plausible(schema *Schema, datas []*Data, encoding int) (float64, bool) {
+	rowidColumn := schema.RowidColumn()
+	agree := 0
+	values := 0
+	for i, d := range datas {
+		if i == rowidColumn {
+			if !d.isNull() {
+				return 0, false
+			}
+			agree++
+			continue
+		}
+		if d.isText() && !validText(d.Bytes, encoding) {
+			return 0, false
+		}
+		if !d.isNull() {
+			values++
+		}
+
+		switch schema.Columns[i].Affinity() {
+		case AffinityInteger, AffinityNumeric:
+			if d.isNull() || d.isInt() || d.isFloat() {
+				agree++
+			}
+		case AffinityReal:
+			if d.isNull() || d.isFloat() || d.isInt() {
+				agree++
+			}
+		case AffinityText:
+			if d.isNull() || d.isText() {
+				agree++
+			}
+		default:
+			agree++
+		}
+	}
+	if values == 0 || 2*agree < len(datas) {
+		return 0, false
+	}
+	return float64(agree) / float64(len(datas)), true
+}
+
+// validText tells whether the bytes are well-formed text in the
+// encoding of the database.
+func validText(bs []byte, encoding int) bool {
+	if encoding != 2 && encoding != 3 {
+		return utf8.Valid(bs)
+	}
+	if len(bs)%2 != 0 {
+		return false
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if encoding == 3 {
+		order = binary.BigEndian
+	}
+	// a surrogate must be the first half of a pair followed by the second
+	for i := 0; i < len(bs); i += 2 {
+		u := order.Uint16(bs[i:])
+		switch {
+		case u >= 0xdc00 && u < 0xe000:
+			return false
+		case u >= 0xd800 && u < 0xdc00:
+			if i+4 > len(bs) {
+				return false
+			}
+			if v := order.Uint16(bs[i+2:]); v < 0xdc00 || v >= 0xe000 {
+				return false
+			}
+			i += 2
+		}
+	}
+	return true
+}
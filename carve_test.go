@@ -0,0 +1,132 @@
+package sqlite3utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func carvedValues(rows []*CarvedRow, table string, column int) []string {
+	ret := []string{}
+	for _, row := range rows {
+		if row.Table == table && column < len(row.Datas) {
+			ret = append(ret, row.Datas[column].Value)
+		}
+	}
+	return ret
+}
+
+func TestCarveFreeblock(t *testing.T) {
+	filename := "/tmp/test_carve.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"PRAGMA secure_delete = off; CREATE TABLE person(id integer primary key, name text, hp integer);",
+		"INSERT INTO person VALUES (1, \"hoge\", 10), (2, \"deleted-foo\", 100), (3, \"bar\", 1000);",
+		"PRAGMA secure_delete = off; DELETE FROM person WHERE id = 2;",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(storage.Tables["person"].Entries))
+
+	rows := Carve(storage)
+	assert.Equal(t, []string{"deleted-foo"}, carvedValues(rows, "person", 1))
+	row := rows[0]
+	assert.Equal(t, 2, row.Page)
+	assert.Equal(t, RegionFreeblock, row.Region)
+	assert.Equal(t, "100", row.Datas[2].Value)
+	assert.True(t, row.Confidence > 0 && row.Confidence <= 1)
+
+	rmSQLite(filename)
+}
+
+func TestCarveAddColumn(t *testing.T) {
+	filename := "/tmp/test_carve.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"PRAGMA secure_delete = off; CREATE TABLE person(id integer primary key, name text);",
+		"INSERT INTO person VALUES (1, \"hoge\"), (2, \"deleted-before\"), (3, \"bar\");",
+		"ALTER TABLE person ADD COLUMN hp integer DEFAULT 5; INSERT INTO person VALUES (4, \"deleted-after\", 7);",
+		"PRAGMA secure_delete = off; DELETE FROM person WHERE id IN (2, 4);",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(storage.Tables["person"].Entries))
+
+	// the row written before the column was added has two fields
+	rows := Carve(storage)
+	assert.ElementsMatch(t, []string{"deleted-before", "deleted-after"}, carvedValues(rows, "person", 1))
+	for _, row := range rows {
+		if row.Datas[1].Value == "deleted-before" {
+			assert.Equal(t, 2, len(row.Datas))
+		} else {
+			assert.Equal(t, "7", row.Datas[2].Value)
+		}
+	}
+
+	rmSQLite(filename)
+}
+
+func TestCarveUTF16(t *testing.T) {
+	filename := "/tmp/test_carve.db"
+	for _, encoding := range []string{"UTF-16le", "UTF-16be"} {
+		rmSQLite(filename)
+		execSQLite(filename, []string{
+			"PRAGMA encoding = \"" + encoding + "\"; PRAGMA secure_delete = off; CREATE TABLE person(id integer primary key, name text, hp integer);",
+			"INSERT INTO person VALUES (1, \"hoge\", 10), (2, \"deleted-\" || char(233, 128512), 100), (3, \"bar\", 1000);",
+			"PRAGMA secure_delete = off; DELETE FROM person WHERE id = 2;",
+		})
+
+		storage, err := Load(filename)
+		assert.Nil(t, err)
+		rows := Carve(storage)
+		assert.Equal(t, 1, len(rows), encoding)
+		if len(rows) == 1 {
+			assert.Equal(t, "deleted-\u00e9\U0001f600", rows[0].Datas[1].text(storage.Header.encoding))
+			assert.Equal(t, "100", rows[0].Datas[2].Value)
+		}
+	}
+
+	// unpaired surrogates are not text
+	assert.True(t, validText([]byte{0x3d, 0xd8, 0x00, 0xde}, 2))
+	assert.False(t, validText([]byte{0x3d, 0xd8, 0x41, 0x00}, 2))
+	assert.False(t, validText([]byte{0xdc, 0x00}, 3))
+	assert.False(t, validText([]byte{0x41}, 2))
+
+	rmSQLite(filename)
+}
+
+func TestCarveFreelist(t *testing.T) {
+	filename := "/tmp/test_carve.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"PRAGMA page_size = 512; CREATE TABLE person(id integer primary key, name text);",
+		"CREATE TABLE memo(body text);",
+		"INSERT INTO person WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 200) SELECT i, \"person-\" || i FROM n;",
+		"PRAGMA secure_delete = off; DELETE FROM person WHERE id > 100; DROP TABLE memo;",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(storage.Tables["person"].Entries))
+	assert.Nil(t, storage.Schema("memo"))
+
+	rows := Carve(storage)
+
+	// the dropped table is found in sqlite_master
+	assert.Contains(t, carvedValues(rows, "sqlite_master", 1), "memo")
+
+	fromFreelist := map[string]bool{}
+	for _, row := range rows {
+		if row.Region == RegionFreelist && row.Table == "person" {
+			fromFreelist[row.Datas[1].Value] = true
+			assert.True(t, row.HasRowid)
+			assert.Equal(t, "person-"+fmt.Sprint(row.Rowid), row.Datas[1].Value)
+		}
+	}
+	assert.True(t, fromFreelist["person-150"])
+
+	rmSQLite(filename)
+}
@@ -0,0 +1,157 @@
+package sqlite3utils
+
+// cell locates a b-tree cell and its payload in the database image.
+type cell struct {
+	page        int // page number
+	index       int // index of the cell on the page
+	offset      int // offset of the cell in the file
+	size        int // bytes the cell occupies on its page
+	child       int // left child page of an interior cell
+	rowid       uint64
+	payloadSize int
+	payload     int // offset of the local part of the payload in the file
+	nLocal      int // payload bytes stored on the page
+	overflow    int // first overflow page, 0 if none
+}
+
+// maxPayload is the largest payload SQLite can store (SQLITE_MAX_LENGTH
+// raised to its hard limit).
+const maxPayload = 0x7fffffff
+
+// varintAt decodes the varint at offset without reading past bytes.
+func varintAt(bytes []byte, offset int) (uint64, int, error) {
+	if offset < 0 || offset >= len(bytes) {
+		return 0, 0, corruptf(0, -1, offset, "varint out of range")
+	}
+	buf := make([]byte, 9)
+	copy(buf, bytes[offset:])
+	v, n := decodeVarint(buf)
+	if offset+int(n) > len(bytes) {
+		return 0, 0, corruptf(0, -1, offset, "varint runs past the end")
+	}
+	return v, int(n), nil
+}
+
+// localPayload returns how many bytes of a payload are stored on a page
+// of the given type.
+// sqlite3/src/btree.c:btreePayloadToLocal
+func localPayload(header *Header, pageType int, payloadSize int) int {
+	maxLocal, minLocal := header.maxLocal, header.minLocal
+	if pageType == leafTable {
+		maxLocal, minLocal = header.maxLeaf, header.minLeaf
+	}
+	if payloadSize <= maxLocal {
+		return payloadSize
+	}
+	surplus := minLocal + (payloadSize-minLocal)%(header.usableSize-4)
+	if surplus <= maxLocal {
+		return surplus
+	}
+	return minLocal
+}
+
+// parseCell decodes the header of the index-th cell on the page.
+func parseCell(bytes []byte, page *Page, header *Header, index int) (*cell, error) {
+	cellPtr := page.cellPtrs[index]
+	pageOffset := header.pageSize * (page.pageNum - 1)
+	pageEnd := pageOffset + header.usableSize
+	if pageEnd > len(bytes) {
+		pageEnd = len(bytes)
+	}
+	c := &cell{page: page.pageNum, index: index, offset: pageOffset + cellPtr}
+	if cellPtr < 0 || c.offset >= pageEnd {
+		return nil, c.corruptf("cell pointer %d out of the page", cellPtr)
+	}
+
+	offset := c.offset
+	if page.pageType == interiorTable || page.pageType == interiorIndex {
+		if offset+4 > pageEnd {
+			return nil, c.corruptf("child pointer runs past the page")
+		}
+		c.child = fetchInt(bytes, offset, 4)
+		offset += 4
+	}
+
+	if page.pageType == interiorTable {
+		v, n, err := varintAt(bytes[:pageEnd], offset)
+		if err != nil {
+			return nil, c.corruptf("bad rowid")
+		}
+		c.rowid = v
+		c.size = offset + n - c.offset
+		return c, nil
+	}
+
+	v, n, err := varintAt(bytes[:pageEnd], offset)
+	if err != nil || v > maxPayload {
+		return nil, c.corruptf("bad payload size")
+	}
+	c.payloadSize = int(v)
+	offset += n
+
+	if page.pageType == leafTable {
+		v, n, err = varintAt(bytes[:pageEnd], offset)
+		if err != nil {
+			return nil, c.corruptf("bad rowid")
+		}
+		c.rowid = v
+		offset += n
+	}
+
+	c.payload = offset
+	c.nLocal = localPayload(header, page.pageType, c.payloadSize)
+	offset += c.nLocal
+	if c.nLocal < c.payloadSize {
+		if offset+4 > pageEnd {
+			return nil, c.corruptf("overflow pointer runs past the page")
+		}
+		c.overflow = fetchInt(bytes, offset, 4)
+		offset += 4
+	}
+	if offset > pageEnd {
+		return nil, c.corruptf("payload of %d bytes runs past the page", c.payloadSize)
+	}
+
+	c.size = offset - c.offset
+	if c.size < 4 {
+		c.size = 4
+	}
+	return c, nil
+}
+
+// overflowPages returns the number of overflow pages the cell needs.
+func (c *cell) overflowPages(header *Header) int {
+	rest := c.payloadSize - c.nLocal
+	if rest <= 0 {
+		return 0
+	}
+	return (rest + header.usableSize - 5) / (header.usableSize - 4)
+}
+
+func (c *cell) corruptf(format string, args ...interface{}) *CorruptError {
+	return corruptf(c.page, c.index, c.offset, format, args...)
+}
+
+// readPayload gathers the whole payload of a cell, following its
+// overflow chain.
+func readPayload(bytes []byte, header *Header, c *cell) ([]byte, error) {
+	if c.overflowPages(header) > len(bytes)/header.pageSize {
+		return nil, c.corruptf("payload of %d bytes is larger than the file", c.payloadSize)
+	}
+
+	payload := fetchCopy(bytes, c.payload, c.nLocal)
+	pageNum := c.overflow
+	for len(payload) < c.payloadSize {
+		if pageNum < 1 || header.pageSize*pageNum > len(bytes) {
+			return nil, c.corruptf("overflow page %d out of range", pageNum)
+		}
+		offset := header.pageSize * (pageNum - 1)
+		n := c.payloadSize - len(payload)
+		if n > header.usableSize-4 {
+			n = header.usableSize - 4
+		}
+		payload = append(payload, fetch(bytes, offset+4, n)...)
+		pageNum = fetchInt(bytes, offset, 4)
+	}
+	return payload, nil
+}
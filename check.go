@@ -0,0 +1,525 @@
+package sqlite3utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Finding is a problem reported by Check.
+type Finding struct {
+	Page    int // page number, 0 if the finding is not about a page
+	Cell    int // cell index on the page, -1 if not about a cell
+	Object  string
+	Message string
+}
+
+func (f *Finding) String() string {
+	ret := ""
+	if f.Object != "" {
+		ret += f.Object + ": "
+	}
+	if f.Page > 0 {
+		ret += fmt.Sprintf("page %d", f.Page)
+		if f.Cell >= 0 {
+			ret += fmt.Sprintf(" cell %d", f.Cell)
+		}
+		ret += ": "
+	}
+	return ret + f.Message
+}
+
+type checker struct {
+	storage  *Storage
+	header   *Header
+	bytes    []byte
+	owners   []string // object using each page, by page number - 1
+	findings []*Finding
+}
+
+// treeEntry is a key found while walking a b-tree.
+type treeEntry struct {
+	rowid  uint64
+	record []*Data
+}
+
+// treeBounds are the keys a subtree must lie between. The lower bound is
+// exclusive; the upper one is inclusive for table trees.
+type treeBounds struct {
+	hasLower bool
+	hasUpper bool
+	lowerKey uint64
+	upperKey uint64
+	lowerRec []*Data
+	upperRec []*Data
+}
+
+func newChecker(storage *Storage) *checker {
+	return &checker{
+		storage:  storage,
+		header:   storage.Header,
+		bytes:    storage.cnt,
+		owners:   make([]string, len(storage.Pages)),
+		findings: []*Finding{},
+	}
+}
+
+// walk marks the pages used by the freelist, the pointer map and every
+// b-tree, checking them on the way. It returns the rows of each rowid
+// table by lower-cased name and the entries of each index.
+func (c *checker) walk() (map[string]map[uint64][]*Data, map[*Schema][]*treeEntry) {
+	c.checkFreelist()
+	c.checkPtrmap()
+
+	tableRows := map[string]map[uint64][]*Data{}
+	indexEntries := map[*Schema][]*treeEntry{}
+
+	c.checkTree(1, "sqlite_master", true, nil)
+	for _, schema := range c.storage.Schemas {
+		if schema.RootPage <= 0 {
+			continue
+		}
+		isTable := schema.Type == "table" && !schema.WithoutRowid
+		entries := c.checkTree(schema.RootPage, schema.Name, isTable, schema)
+		if isTable {
+			rows := map[uint64][]*Data{}
+			for _, e := range entries {
+				rows[e.rowid] = e.record
+			}
+			tableRows[strings.ToLower(schema.Name)] = rows
+		} else if schema.Type == "index" {
+			indexEntries[schema] = entries
+		}
+	}
+	return tableRows, indexEntries
+}
+
+// Check verifies the structure of the database like
+// PRAGMA integrity_check and returns every problem found. A healthy
+// database yields no findings.
+func Check(storage *Storage) []*Finding {
+	c := newChecker(storage)
+
+	c.checkHeader()
+	tableRows, indexEntries := c.walk()
+
+	for pageNum, owner := range c.owners {
+		if owner == "" && !isLockBytePage(pageNum+1, c.header) {
+			c.add(pageNum+1, -1, "", "page is never used")
+		}
+	}
+
+	for _, schema := range storage.Schemas {
+		entries, ok := indexEntries[schema]
+		if !ok {
+			continue
+		}
+		rows, ok := tableRows[strings.ToLower(schema.TableName)]
+		if !ok {
+			continue
+		}
+		c.checkIndex(schema, storage.Schema(schema.TableName), entries, rows)
+	}
+
+	return c.findings
+}
+
+func (c *checker) add(pageNum, cell int, object, format string, args ...interface{}) {
+	c.findings = append(c.findings, &Finding{
+		Page:    pageNum,
+		Cell:    cell,
+		Object:  object,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// use records that owner references the page. It reports pages out of
+// range and pages referenced twice, and returns false for them.
+func (c *checker) use(pageNum int, owner string, from, cell int) bool {
+	if pageNum < 1 || pageNum > len(c.owners) {
+		c.add(from, cell, owner, "invalid page number %d", pageNum)
+		return false
+	}
+	if isLockBytePage(pageNum, c.header) {
+		c.add(from, cell, owner, "reference to the lock-byte page %d", pageNum)
+		return false
+	}
+	if c.owners[pageNum-1] != "" {
+		c.add(from, cell, owner, "page %d is also used by %s", pageNum, c.owners[pageNum-1])
+		return false
+	}
+	c.owners[pageNum-1] = owner
+	return true
+}
+
+func (c *checker) checkHeader() {
+	if c.header.headerString != "SQLite format 3\x00" {
+		c.add(1, -1, "", "bad header string %q", c.header.headerString)
+	}
+	if c.header.inHeaderDbSize != 0 && c.header.vvfNum == c.header.changeCounter &&
+		c.header.inHeaderDbSize != len(c.storage.Pages) {
+		c.add(1, -1, "", "database size is %d pages, header says %d", len(c.storage.Pages), c.header.inHeaderDbSize)
+	}
+}
+
+func (c *checker) checkFreelist() {
+	pageNum := c.header.freeTrunk1st
+	from := 1
+	count := 0
+	for pageNum != 0 {
+		if !c.use(pageNum, "freelist", from, -1) {
+			return
+		}
+		count++
+
+		offset := c.header.pageSize * (pageNum - 1)
+		leafCount := fetchInt(c.bytes, offset+4, 4)
+		if leafCount > c.header.usableSize/4-2 {
+			c.add(pageNum, -1, "freelist", "trunk page has %d leaves", leafCount)
+			return
+		}
+		for i := 0; i < leafCount; i++ {
+			if c.use(fetchInt(c.bytes, offset+8+4*i, 4), "freelist", pageNum, -1) {
+				count++
+			}
+		}
+
+		from = pageNum
+		pageNum = fetchInt(c.bytes, offset, 4)
+	}
+
+	if count != c.header.totalFree {
+		c.add(0, -1, "freelist", "freelist has %d pages, header says %d", count, c.header.totalFree)
+	}
+}
+
+// checkPtrmap marks the pointer-map pages of an auto-vacuum database.
+func (c *checker) checkPtrmap() {
+	for pageNum := 2; pageNum <= len(c.owners); pageNum++ {
+		if isPtrmapPage(pageNum, c.header) {
+			c.use(pageNum, "ptrmap", 0, -1)
+		}
+	}
+}
+
+// checkOverflow walks an overflow chain that must have n pages.
+func (c *checker) checkOverflow(pageNum, n int, owner string, from, cell int) {
+	count := 0
+	for pageNum != 0 && count < n {
+		if !c.use(pageNum, owner, from, cell) {
+			return
+		}
+		count++
+		pageNum = fetchInt(c.bytes, c.header.pageSize*(pageNum-1), 4)
+	}
+	if count < n {
+		c.add(from, cell, owner, "overflow chain has %d pages, payload needs %d", count, n)
+	} else if pageNum != 0 {
+		c.add(from, cell, owner, "overflow chain is longer than the %d pages the payload needs", n)
+	}
+}
+
+func isBinary(collate string) bool {
+	return collate == "" || strings.EqualFold(collate, "binary")
+}
+
+// keyCompare returns the comparison of the records of an index on
+// table, or nil if their order cannot be checked.
+func keyCompare(index, table *Schema, encoding int) func(a, b []*Data) int {
+	if index == nil || index.Type != "index" {
+		// the key of a WITHOUT ROWID table is its primary key, not
+		// the columns in declaration order
+		return nil
+	}
+	if index.SQL == "" && table != nil {
+		// an automatic index uses the collations of the table
+		for _, column := range table.Columns {
+			if !isBinary(column.Collate) {
+				return nil
+			}
+		}
+	}
+
+	k, err := indexKeyInfo(index, table, encoding)
+	if err != nil {
+		// an unknown collation
+		return nil
+	}
+	return k.compare
+}
+
+// checkTree walks the b-tree rooted at root and returns its entries in
+// key order.
+func (c *checker) checkTree(root int, owner string, isTable bool, schema *Schema) []*treeEntry {
+	entries := []*treeEntry{}
+	var compare func(a, b []*Data) int
+	if schema != nil {
+		compare = keyCompare(schema, c.storage.Schema(schema.TableName), c.header.encoding)
+	}
+	c.checkTreePage(root, owner, isTable, compare, &treeBounds{}, 0, -1, &entries)
+	return entries
+}
+
+// checkTreePage checks a page of a b-tree and its subtrees. It returns
+// the depth of the leaves below it, or -1 if the page is unusable.
+func (c *checker) checkTreePage(pageNum int, owner string, isTable bool, compare func(a, b []*Data) int,
+	bounds *treeBounds, from, fromCell int, entries *[]*treeEntry) int {
+
+	if !c.use(pageNum, owner, from, fromCell) {
+		return -1
+	}
+	page := c.storage.Pages[pageNum-1]
+
+	interior, leaf := interiorIndex, leafIndex
+	if isTable {
+		interior, leaf = interiorTable, leafTable
+	}
+	if page.pageType != interior && page.pageType != leaf {
+		c.add(pageNum, -1, owner, "invalid page type %d", page.pageType)
+		return -1
+	}
+
+	cells := c.checkPageLayout(page, owner)
+	if cells == nil {
+		return -1
+	}
+
+	depth := -1
+	var prevKey uint64
+	var prevRec []*Data
+	hasPrev := false
+	for i, cl := range cells {
+		if cl == nil {
+			continue
+		}
+
+		var record []*Data
+		if cl.overflow != 0 {
+			c.checkOverflow(cl.overflow, cl.overflowPages(c.header), owner, pageNum, i)
+		}
+		if page.pageType != interiorTable {
+			payload, err := readPayload(c.bytes, c.header, cl)
+			if err == nil {
+				record, err = decodeRecord(payload)
+			}
+			if err != nil {
+				c.add(pageNum, i, owner, "%s", reason(err))
+				continue
+			}
+		}
+
+		// keys increase across the page and stay within the bounds
+		if isTable {
+			if hasPrev && cl.rowid <= prevKey {
+				c.add(pageNum, i, owner, "rowid %d out of order", cl.rowid)
+			} else if bounds.hasLower && cl.rowid <= bounds.lowerKey ||
+				bounds.hasUpper && cl.rowid > bounds.upperKey {
+				c.add(pageNum, i, owner, "rowid %d out of range", cl.rowid)
+			}
+		} else if compare != nil {
+			if hasPrev && compare(record, prevRec) <= 0 {
+				c.add(pageNum, i, owner, "index key out of order")
+			} else if bounds.hasLower && compare(record, bounds.lowerRec) <= 0 ||
+				bounds.hasUpper && compare(record, bounds.upperRec) >= 0 {
+				c.add(pageNum, i, owner, "index key out of range")
+			}
+		}
+
+		if page.pageType == interior {
+			child := &treeBounds{
+				hasLower: hasPrev || bounds.hasLower,
+				lowerKey: bounds.lowerKey,
+				lowerRec: bounds.lowerRec,
+				hasUpper: true,
+				upperKey: cl.rowid,
+				upperRec: record,
+			}
+			if hasPrev {
+				child.lowerKey, child.lowerRec = prevKey, prevRec
+			}
+			depth = c.mergeDepth(pageNum, owner, depth,
+				c.checkTreePage(cl.child, owner, isTable, compare, child, pageNum, i, entries))
+		}
+
+		if page.pageType == leaf || !isTable {
+			*entries = append(*entries, &treeEntry{rowid: cl.rowid, record: record})
+		}
+		prevKey, prevRec, hasPrev = cl.rowid, record, true
+	}
+
+	if page.pageType == leaf {
+		return 0
+	}
+
+	child := &treeBounds{
+		hasLower: hasPrev || bounds.hasLower,
+		lowerKey: bounds.lowerKey,
+		lowerRec: bounds.lowerRec,
+		hasUpper: bounds.hasUpper,
+		upperKey: bounds.upperKey,
+		upperRec: bounds.upperRec,
+	}
+	if hasPrev {
+		child.lowerKey, child.lowerRec = prevKey, prevRec
+	}
+	depth = c.mergeDepth(pageNum, owner, depth,
+		c.checkTreePage(page.rightPtr, owner, isTable, compare, child, pageNum, -1, entries))
+	if depth < 0 {
+		return -1
+	}
+	return depth + 1
+}
+
+func (c *checker) mergeDepth(pageNum int, owner string, depth, childDepth int) int {
+	if childDepth < 0 {
+		return depth
+	}
+	if depth >= 0 && depth != childDepth {
+		c.add(pageNum, -1, owner, "child pages differ in depth")
+	}
+	return childDepth
+}
+
+// checkPageLayout checks the page header, the cell pointers, the
+// freeblock chain and the accounting of the content area. It returns
+// the cells of the page, with nil for cells that cannot be read, or nil
+// if the page header is unusable.
+func (c *checker) checkPageLayout(page *Page, owner string) []*cell {
+	pageNum := page.pageNum
+	usable := c.header.usableSize
+
+	headerOffset := 0
+	if pageNum == 1 {
+		headerOffset = 100
+	}
+	headerSize := 8
+	if page.pageType == interiorIndex || page.pageType == interiorTable {
+		headerSize = 12
+	}
+	ptrEnd := headerOffset + headerSize + 2*page.cellCount
+	startCellPtr := page.startCellPtr
+	if startCellPtr > usable || ptrEnd > startCellPtr {
+		c.add(pageNum, -1, owner, "%d cells do not fit before the content area at %d", page.cellCount, startCellPtr)
+		return nil
+	}
+	if page.fragments > 60 {
+		c.add(pageNum, -1, owner, "%d fragmented bytes", page.fragments)
+	}
+
+	findings := len(c.findings)
+
+	// each byte of the content area belongs to at most one cell or freeblock
+	owned := make([]bool, usable)
+	claim := func(start, size int) bool {
+		overlap := false
+		for i := start; i < start+size && i < usable; i++ {
+			overlap = overlap || owned[i]
+			owned[i] = true
+		}
+		return !overlap
+	}
+
+	used := 0
+	cells := make([]*cell, len(page.cellPtrs))
+	for i, cellPtr := range page.cellPtrs {
+		if cellPtr < startCellPtr || cellPtr >= usable {
+			c.add(pageNum, i, owner, "cell pointer %d outside the content area", cellPtr)
+			continue
+		}
+		cl, err := parseCell(c.bytes, page, c.header, i)
+		if err != nil {
+			c.add(pageNum, i, owner, "%s", reason(err))
+			continue
+		}
+		if cellPtr+cl.size > usable {
+			c.add(pageNum, i, owner, "cell extends past the usable size")
+			continue
+		}
+		if !claim(cellPtr, cl.size) {
+			c.add(pageNum, i, owner, "cell overlaps another cell or freeblock")
+		}
+		used += cl.size
+		cells[i] = cl
+	}
+
+	pageOffset := c.header.pageSize * (pageNum - 1)
+	free := 0
+	prev := 0
+	for block := page.freeBlock; block != 0; {
+		if block <= prev || block < startCellPtr || block+4 > usable {
+			c.add(pageNum, -1, owner, "freeblock at %d out of order or out of range", block)
+			break
+		}
+		next := fetchInt(c.bytes, pageOffset+block, 2)
+		size := fetchInt(c.bytes, pageOffset+block+2, 2)
+		if size < 4 || block+size > usable {
+			c.add(pageNum, -1, owner, "freeblock at %d has bad size %d", block, size)
+			break
+		}
+		if !claim(block, size) {
+			c.add(pageNum, -1, owner, "freeblock at %d overlaps a cell", block)
+		}
+		free += size
+		prev = block + size - 1
+		block = next
+	}
+
+	if used+free+page.fragments != usable-startCellPtr && len(c.findings) == findings {
+		c.add(pageNum, -1, owner, "fragmentation of %d bytes reported as %d on page",
+			usable-startCellPtr-used-free, page.fragments)
+	}
+
+	return cells
+}
+
+// checkIndex checks that every entry of an index points to a row of its
+// table holding the same values, and that no row is missing.
+func (c *checker) checkIndex(index, table *Schema, entries []*treeEntry, rows map[uint64][]*Data) {
+	rowidColumn := -1
+	columns := []int{}
+	if table != nil {
+		rowidColumn = table.RowidColumn()
+		for _, column := range index.Columns {
+			if column.Name == "" {
+				columns = append(columns, -1)
+			} else {
+				columns = append(columns, table.Column(column.Name))
+			}
+		}
+	}
+
+	for _, e := range entries {
+		if len(e.record) == 0 || !e.record[len(e.record)-1].isInt() {
+			c.add(index.RootPage, -1, index.Name, "index entry without rowid")
+			continue
+		}
+		rowid := uint64(e.record[len(e.record)-1].int64())
+		row, ok := rows[rowid]
+		if !ok {
+			c.add(index.RootPage, -1, index.Name, "row %d missing from %s", rowid, index.TableName)
+			continue
+		}
+
+		for i, n := range columns {
+			if n < 0 || n == rowidColumn || i >= len(e.record)-1 {
+				continue
+			}
+			var value *Data
+			if n < len(row) {
+				value = row[n]
+			} else {
+				// a row written before ALTER TABLE ADD COLUMN
+				column := table.Columns[n]
+				value = valueData(applyAffinity(column.defaultValue(), column.Affinity()), c.header)
+			}
+			if compareData(e.record[i], value) != 0 {
+				c.add(index.RootPage, -1, index.Name, "entry for row %d has %s = %q, table has %q",
+					rowid, index.Columns[i].Name, e.record[i].Value, value.Value)
+			}
+		}
+	}
+
+	if !index.Partial && len(entries) != len(rows) {
+		c.add(index.RootPage, -1, index.Name, "wrong # of entries in index: %d, table has %d rows",
+			len(entries), len(rows))
+	}
+}
@@ -0,0 +1,73 @@
+package sqlite3utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	filename := "/tmp/test_check.db"
+	rmSQLite(filename)
+
+	cmd := []string{
+		"CREATE TABLE person(id integer primary key, name text, memo text);",
+		"CREATE INDEX person_name ON person(name DESC);",
+		"CREATE TABLE tmp(v text);",
+		"WITH RECURSIVE c(i) AS (SELECT 0 UNION ALL SELECT i+1 FROM c WHERE i < 499) " +
+			"INSERT INTO person SELECT i, printf(\"name%03d\", i), hex(zeroblob(i*5)) FROM c;",
+		"WITH RECURSIVE c(i) AS (SELECT 0 UNION ALL SELECT i+1 FROM c WHERE i < 499) " +
+			"INSERT INTO tmp SELECT hex(zeroblob(50)) FROM c;",
+		"DELETE FROM tmp;",
+	}
+	execSQLite(filename, cmd)
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	assert.True(t, storage.Header.totalFree > 0)
+	assert.Equal(t, []*Finding{}, Check(storage))
+
+	// Change an index entry behind the table's back.
+	cnt, _ := ioutil.ReadFile(filename)
+	pageSize := storage.Header.pageSize
+	for _, page := range storage.Pages {
+		if page.pageType != leafIndex {
+			continue
+		}
+		offset := pageSize * (page.pageNum - 1)
+		i := bytes.Index(cnt[offset:offset+pageSize], []byte("name250"))
+		if i >= 0 {
+			copy(cnt[offset+i:], "name25x")
+		}
+	}
+	ioutil.WriteFile(filename, cnt, 0644)
+
+	storage, err = Load(filename)
+	assert.Nil(t, err)
+	findings := Check(storage)
+	assert.NotEqual(t, 0, len(findings))
+	for _, f := range findings {
+		assert.Equal(t, "person_name", f.Object, f.String())
+	}
+
+	rmSQLite(filename)
+}
+
+func TestCheckAddColumn(t *testing.T) {
+	filename := "/tmp/test_check_add_column.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"CREATE TABLE p(id, name); INSERT INTO p VALUES (1, \"a\"), (2, \"b\");",
+		"ALTER TABLE p ADD COLUMN hp integer DEFAULT 5; INSERT INTO p VALUES (3, \"c\", 7);",
+		"CREATE INDEX p_hp ON p(hp);",
+	})
+
+	// the index holds the DEFAULT of the rows written before the column
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, []*Finding{}, Check(storage))
+
+	rmSQLite(filename)
+}
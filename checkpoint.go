@@ -0,0 +1,122 @@
+package sqlite3utils
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"os"
+)
+
+// CheckpointMode selects what Checkpoint does with the WAL file after
+// its pages are copied into the database.
+type CheckpointMode int
+
+const (
+	// CheckpointPassive leaves the WAL file untouched.
+	CheckpointPassive CheckpointMode = iota
+	// CheckpointRestart rewrites the WAL header with new salts so that
+	// the next writer starts from the beginning of the file.
+	CheckpointRestart
+	// CheckpointTruncate truncates the WAL file to zero bytes.
+	CheckpointTruncate
+)
+
+// Checkpoint copies the latest committed version of every page in the
+// WAL file of the database at path into the database file, without a
+// running SQLite. No other process may have the database open.
+//
+// The database file is synced before the WAL is reset, so an
+// interrupted checkpoint leaves a WAL that still holds every committed
+// page and can simply be checkpointed again.
+func Checkpoint(path string, mode CheckpointMode) error {
+	walPath := path + "-wal"
+	wal, err := loadWAL(walPath)
+	if err != nil {
+		return err
+	}
+	if wal == nil {
+		return nil
+	}
+
+	if wal.mxFrame > 0 {
+		if err := backfill(path, wal); err != nil {
+			return err
+		}
+	}
+
+	switch mode {
+	case CheckpointRestart:
+		if wal.Header == nil {
+			return os.Truncate(walPath, 0)
+		}
+		return resetWAL(walPath, wal.Header)
+	case CheckpointTruncate:
+		return os.Truncate(walPath, 0)
+	}
+	return nil
+}
+
+// backfill writes the committed frames of wal into the database file.
+func backfill(path string, wal *WAL) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	pageSize := wal.Header.pageSize
+	dbSize := wal.Frames[wal.mxFrame-1].dbSize
+
+	for pageNum, frame := range wal.latestFrames(wal.mxFrame) {
+		if pageNum > dbSize {
+			continue
+		}
+		data := frame.data
+		if pageNum == 1 {
+			data = updateFileHeader(data, dbSize)
+		}
+		if _, err := file.WriteAt(data, int64(pageSize*(pageNum-1))); err != nil {
+			return err
+		}
+	}
+
+	if err := file.Truncate(int64(pageSize * dbSize)); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// updateFileHeader returns a copy of the first page with the change
+// counter incremented and the in-header database size set to dbSize.
+func updateFileHeader(page []byte, dbSize int) []byte {
+	ret := make([]byte, len(page))
+	copy(ret, page)
+
+	counter := binary.BigEndian.Uint32(ret[24:]) + 1
+	binary.BigEndian.PutUint32(ret[24:], counter)
+	binary.BigEndian.PutUint32(ret[28:], uint32(dbSize))
+	binary.BigEndian.PutUint32(ret[92:], counter) // version-valid-for
+	return ret
+}
+
+// resetWAL replaces the WAL file with a bare header carrying new salts,
+// which invalidates every existing frame.
+func resetWAL(path string, header *WALHeader) error {
+	next := *header
+	next.checkpointSeq++
+	next.salt1++
+	next.salt2 = rand.Uint32()
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.WriteAt(next.bytes(), 0); err != nil {
+		return err
+	}
+	if err := file.Truncate(walHeaderSize); err != nil {
+		return err
+	}
+	return file.Sync()
+}
@@ -0,0 +1,56 @@
+package sqlite3utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	filename := "/tmp/test_checkpoint.db"
+
+	for _, mode := range []CheckpointMode{CheckpointPassive, CheckpointRestart, CheckpointTruncate} {
+		rmSQLite(filename)
+		rmSQLite(filename + "-wal")
+		rmSQLite(filename + "-shm")
+
+		execSQLite(filename, []string{
+			"PRAGMA journal_mode=WAL;",
+			noCheckpoint + "CREATE TABLE person(id integer, name text);",
+			noCheckpoint + "INSERT INTO person VALUES (1, \"hoge\");",
+			noCheckpoint + "INSERT INTO person VALUES (2, \"foo\");",
+		})
+
+		assert.Nil(t, Checkpoint(filename, mode))
+
+		info, err := os.Stat(filename + "-wal")
+		assert.Nil(t, err)
+		switch mode {
+		case CheckpointPassive:
+			assert.True(t, info.Size() > walHeaderSize)
+		case CheckpointRestart:
+			assert.Equal(t, int64(walHeaderSize), info.Size())
+		case CheckpointTruncate:
+			assert.Equal(t, int64(0), info.Size())
+		}
+
+		// The database file alone must now hold the data.
+		os.Rename(filename+"-wal", filename+"-wal.bak")
+		storage, err := Load(filename)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(storage.Tables["person"].Entries))
+		assert.Equal(t, "foo", storage.Tables["person"].Entries[1].Datas[1].Value)
+		assert.Equal(t, len(storage.Pages), storage.Header.inHeaderDbSize)
+		os.Rename(filename+"-wal.bak", filename+"-wal")
+
+		// A reset WAL must not be replayed.
+		storage, err = Load(filename)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(storage.Tables["person"].Entries))
+	}
+
+	rmSQLite(filename)
+	rmSQLite(filename + "-wal")
+	rmSQLite(filename + "-shm")
+}
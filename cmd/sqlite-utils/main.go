@@ -0,0 +1,352 @@
+// Command sqlite-utils inspects SQLite database files without sqlite3.
+//
+//	sqlite-utils [flags] COMMAND DATABASE [ARGS]
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	sqlite3utils "github.com/kawakami-o3/go-sqlite3-utils"
+)
+
+type command struct {
+	args  string
+	usage string
+	run   func(storage *sqlite3utils.Storage, args []string, out io.Writer) error
+}
+
+var commands = map[string]*command{
+	"header":   {"", "print the fields of the database header", header},
+	"tables":   {"", "list the tables and their row counts", tables},
+	"schema":   {"", "print the CREATE statements", schema},
+	"pages":    {"", "print the type and owner of every page", pages},
+	"page":     {"N", "print the layout and cells of page N", page},
+	"dump":     {"TABLE", "print the rows of TABLE", dump},
+	"sql":      {"", "print the database as SQL like sqlite3 .dump", sql},
+	"export":   {"TABLE", "print the rows of TABLE as CSV or JSON Lines", export},
+	"import":   {"TABLE FILE", "append CSV or JSON Lines from FILE to TABLE, which must have no index", importFile},
+	"check":    {"", "check the integrity of the database", check},
+	"freelist": {"", "list the pages of the freelist", freelist},
+	"analyze":  {"", "report the space used by each table and index", analyze},
+}
+
+var errFindings = errors.New("integrity check failed")
+
+var (
+	recoverFlag = flag.Bool("recover", false, "skip corrupt pages and cells instead of failing")
+	jsonFlag    = flag.Bool("json", false, "print the analyze report as JSON")
+	realFlag    = flag.String("real", "sqlite", "dump format of REAL values: sqlite or shortest")
+	workersFlag = flag.Int("workers", 1, "goroutines parsing the pages of the database")
+
+	formatFlag    = flag.String("format", "csv", "export and import format: csv or jsonl")
+	delimiterFlag = flag.String("delimiter", ",", "CSV field delimiter")
+	nullFlag      = flag.String("null", "", "CSV text for NULL")
+	blobFlag      = flag.String("blob", "hex", "blob encoding: hex or base64")
+	batchFlag     = flag.Int("batch", 0, "import rows per transaction, all in one if 0")
+)
+
+func usage(out io.Writer) {
+	fmt.Fprintf(out, "usage: sqlite-utils [flags] COMMAND DATABASE [ARGS]\n\ncommands:\n")
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := commands[name]
+		fmt.Fprintf(out, "  %-16s %s\n", strings.TrimSpace(name+" "+c.args), c.usage)
+	}
+	fmt.Fprintf(out, "\nflags:\n")
+	flag.CommandLine.SetOutput(out)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = func() { usage(os.Stderr) }
+	flag.Parse()
+	os.Exit(run(flag.Args(), os.Stdout, os.Stderr))
+}
+
+func run(args []string, out, errOut io.Writer) int {
+	if len(args) < 2 {
+		usage(errOut)
+		return 2
+	}
+	c, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(errOut, "sqlite-utils: unknown command %q\n", args[0])
+		return 2
+	}
+
+	options := sqlite3utils.LoadOptions{Recover: *recoverFlag, Workers: *workersFlag}
+	switch *realFlag {
+	case "sqlite":
+		options.RealFormat = sqlite3utils.RealSQLite
+	case "shortest":
+		options.RealFormat = sqlite3utils.RealShortest
+	default:
+		fmt.Fprintf(errOut, "sqlite-utils: unknown REAL format %q\n", *realFlag)
+		return 2
+	}
+
+	storage, err := sqlite3utils.LoadWithOptions(args[1], options)
+	if err != nil {
+		fmt.Fprintf(errOut, "sqlite-utils: %v\n", err)
+		return 1
+	}
+	for _, d := range storage.Diagnostics {
+		fmt.Fprintf(errOut, "sqlite-utils: skipped: %v\n", d)
+	}
+
+	if err := c.run(storage, args[2:], out); err == errFindings {
+		return 1
+	} else if err != nil {
+		fmt.Fprintf(errOut, "sqlite-utils: %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func header(storage *sqlite3utils.Storage, args []string, out io.Writer) error {
+	for _, f := range storage.Header.Fields() {
+		fmt.Fprintf(out, "%-21s %d\n", f.Name+":", f.Value)
+	}
+	return nil
+}
+
+func tables(storage *sqlite3utils.Storage, args []string, out io.Writer) error {
+	for _, c := range storage.RowCounts() {
+		if c.Type == "table" && c.Name != "sqlite_master" {
+			fmt.Fprintf(out, "%s\t%d\n", c.Name, c.Rows)
+		}
+	}
+	return nil
+}
+
+func schema(storage *sqlite3utils.Storage, args []string, out io.Writer) error {
+	for _, s := range storage.Schemas {
+		if s.SQL != "" {
+			fmt.Fprintf(out, "%s;\n", s.SQL)
+		}
+	}
+	return nil
+}
+
+func pages(storage *sqlite3utils.Storage, args []string, out io.Writer) error {
+	for _, info := range storage.InspectPages() {
+		fmt.Fprintf(out, "%6d  %-15s %s\n", info.Number, info.Type, info.Owner)
+	}
+	return nil
+}
+
+func page(storage *sqlite3utils.Storage, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("expected a page number")
+	}
+	pageNum, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+	info, err := storage.InspectPage(pageNum)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "page %d: %s", info.Number, info.Type)
+	if info.Owner != "" {
+		fmt.Fprintf(out, " of %s", info.Owner)
+	}
+	fmt.Fprintln(out)
+	if info.CellContentStart == 0 {
+		// not a b-tree page
+		return nil
+	}
+
+	fmt.Fprintf(out, "cells: %d, content area: %d, fragmented bytes: %d\n",
+		info.CellCount, info.CellContentStart, info.FragmentedBytes)
+	if info.RightPointer != 0 {
+		fmt.Fprintf(out, "right child: %d\n", info.RightPointer)
+	}
+	for _, b := range info.Freeblocks {
+		fmt.Fprintf(out, "freeblock at %d: %d bytes\n", b.Offset, b.Size)
+	}
+	for i, c := range info.Cells {
+		fmt.Fprintf(out, "cell %d at %d:", i, c.Offset)
+		if c.Err != nil {
+			fmt.Fprintf(out, " %v\n", c.Err)
+			continue
+		}
+		fmt.Fprintf(out, " %d bytes", c.Size)
+		if c.LeftChild != 0 {
+			fmt.Fprintf(out, ", child %d", c.LeftChild)
+		}
+		if info.Type == sqlite3utils.PageLeafTable || info.Type == sqlite3utils.PageInteriorTable {
+			fmt.Fprintf(out, ", rowid %d", c.Rowid)
+		}
+		if info.Type != sqlite3utils.PageInteriorTable {
+			fmt.Fprintf(out, ", payload %d (%d local)", c.PayloadSize, c.LocalPayload)
+		}
+		if c.OverflowPage != 0 {
+			fmt.Fprintf(out, ", overflow %d", c.OverflowPage)
+		}
+		fmt.Fprintln(out)
+	}
+	return nil
+}
+
+func dump(storage *sqlite3utils.Storage, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("expected a table name")
+	}
+	table, ok := storage.Tables[args[0]]
+	if !ok {
+		return fmt.Errorf("no such table: %s", args[0])
+	}
+	for _, e := range table.Entries {
+		values := []string{}
+		for _, d := range e.Datas {
+			values = append(values, d.Value)
+		}
+		fmt.Fprintln(out, strings.Join(values, "|"))
+	}
+	return nil
+}
+
+func sql(storage *sqlite3utils.Storage, args []string, out io.Writer) error {
+	return sqlite3utils.Dump(storage, out)
+}
+
+func blobEncoding() (sqlite3utils.BlobEncoding, error) {
+	switch *blobFlag {
+	case "hex":
+		return sqlite3utils.BlobHex, nil
+	case "base64":
+		return sqlite3utils.BlobBase64, nil
+	}
+	return 0, fmt.Errorf("unknown blob encoding %q", *blobFlag)
+}
+
+func delimiter() (rune, error) {
+	delimiter := []rune(*delimiterFlag)
+	if len(delimiter) != 1 {
+		return 0, fmt.Errorf("delimiter must be one character, not %q", *delimiterFlag)
+	}
+	return delimiter[0], nil
+}
+
+func export(storage *sqlite3utils.Storage, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("expected a table name")
+	}
+	blob, err := blobEncoding()
+	if err != nil {
+		return err
+	}
+
+	switch *formatFlag {
+	case "csv":
+		delimiter, err := delimiter()
+		if err != nil {
+			return err
+		}
+		return sqlite3utils.ExportCSV(storage, args[0], out, sqlite3utils.CSVOptions{
+			Delimiter: delimiter,
+			Null:      *nullFlag,
+			Blob:      blob,
+		})
+	case "jsonl":
+		return sqlite3utils.ExportJSONLines(storage, args[0], out, sqlite3utils.JSONOptions{Blob: blob})
+	}
+	return fmt.Errorf("unknown format %q", *formatFlag)
+}
+
+func importFile(storage *sqlite3utils.Storage, args []string, out io.Writer) error {
+	if len(args) != 2 {
+		return errors.New("expected a table name and a file")
+	}
+	blob, err := blobEncoding()
+	if err != nil {
+		return err
+	}
+	delimiter, err := delimiter()
+	if err != nil {
+		return err
+	}
+	options := sqlite3utils.ImportOptions{
+		Delimiter: delimiter,
+		Null:      *nullFlag,
+		Blob:      blob,
+		BatchSize: *batchFlag,
+		Writer:    sqlite3utils.WriterOptions{WAL: storage.Header.WALMode()},
+	}
+
+	in, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var result *sqlite3utils.ImportResult
+	switch *formatFlag {
+	case "csv":
+		result, err = sqlite3utils.ImportCSV(storage.Path, args[0], in, options)
+	case "jsonl":
+		result, err = sqlite3utils.ImportJSONLines(storage.Path, args[0], in, options)
+	default:
+		return fmt.Errorf("unknown format %q", *formatFlag)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, r := range result.Rejected {
+		fmt.Fprintf(out, "rejected %v\n", r)
+	}
+	fmt.Fprintf(out, "inserted %d rows into %s\n", result.Inserted, args[0])
+	return nil
+}
+
+func check(storage *sqlite3utils.Storage, args []string, out io.Writer) error {
+	findings := sqlite3utils.Check(storage)
+	if len(findings) == 0 {
+		fmt.Fprintln(out, "ok")
+		return nil
+	}
+	for _, f := range findings {
+		fmt.Fprintln(out, f)
+	}
+	return errFindings
+}
+
+func freelist(storage *sqlite3utils.Storage, args []string, out io.Writer) error {
+	trunks, leaves, err := storage.Freelist()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "trunk pages: %s\n", joinInts(trunks))
+	fmt.Fprintf(out, "leaf pages: %s\n", joinInts(leaves))
+	return nil
+}
+
+func analyze(storage *sqlite3utils.Storage, args []string, out io.Writer) error {
+	report := sqlite3utils.Analyze(storage)
+	if *jsonFlag {
+		return report.WriteJSON(out)
+	}
+	return report.WriteText(out)
+}
+
+func joinInts(ns []int) string {
+	ret := []string{}
+	for _, n := range ns {
+		ret = append(ret, strconv.Itoa(n))
+	}
+	return strings.Join(ret, " ")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runCommand(args ...string) (int, string, string) {
+	out := &bytes.Buffer{}
+	errOut := &bytes.Buffer{}
+	status := run(args, out, errOut)
+	return status, out.String(), errOut.String()
+}
+
+func TestCommands(t *testing.T) {
+	db := "../../testdata/simple.db"
+
+	status, out, _ := runCommand("header", db)
+	assert.Equal(t, 0, status)
+	assert.True(t, strings.HasPrefix(out, "database page size:   512\n"))
+
+	_, out, _ = runCommand("tables", db)
+	assert.Equal(t, "person\t4\n", out)
+
+	_, out, _ = runCommand("schema", db)
+	assert.Equal(t, "CREATE TABLE person(id integer, name text, hp integer);\n", out)
+
+	_, out, _ = runCommand("pages", db)
+	assert.Equal(t, "     1  leaf table      sqlite_master\n     2  leaf table      person\n", out)
+
+	_, out, _ = runCommand("page", db, "2")
+	assert.True(t, strings.HasPrefix(out, "page 2: leaf table of person\ncells: 4,"))
+
+	_, out, _ = runCommand("dump", db, "person")
+	assert.Equal(t, "1|hoge|10\n2|foo|100\n3|bar|-1000\n4||1.5\n", out)
+
+	_, out, _ = runCommand("sql", db)
+	assert.True(t, strings.HasPrefix(out, "PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\nCREATE TABLE person"))
+
+	_, out, _ = runCommand("export", db, "person")
+	assert.True(t, strings.HasPrefix(out, "id,name,hp\n1,hoge,10\n"))
+
+	*formatFlag = "jsonl"
+	_, out, _ = runCommand("export", db, "person")
+	*formatFlag = "csv"
+	assert.True(t, strings.HasPrefix(out, `{"id":1,"name":"hoge","hp":10}`+"\n"))
+
+	_, out, _ = runCommand("check", db)
+	assert.Equal(t, "ok\n", out)
+
+	_, out, _ = runCommand("freelist", "../../testdata/freelist.db")
+	assert.True(t, strings.HasPrefix(out, "trunk pages: "))
+
+	_, out, _ = runCommand("analyze", db)
+	assert.True(t, strings.Contains(out, "*** Table person ***"))
+}
+
+func TestCommandErrors(t *testing.T) {
+	status, _, _ := runCommand("header")
+	assert.Equal(t, 2, status)
+
+	status, _, errOut := runCommand("nosuch", "../../testdata/simple.db")
+	assert.Equal(t, 2, status)
+	assert.Equal(t, "sqlite-utils: unknown command \"nosuch\"\n", errOut)
+
+	status, _, errOut = runCommand("dump", "../../testdata/simple.db", "nosuch")
+	assert.Equal(t, 1, status)
+	assert.Equal(t, "sqlite-utils: dump: no such table: nosuch\n", errOut)
+
+	status, _, _ = runCommand("page", "../../testdata/simple.db", "9")
+	assert.Equal(t, 1, status)
+}
+
+func TestImport(t *testing.T) {
+	data, err := os.ReadFile("../../testdata/simple.db")
+	assert.Nil(t, err)
+	db := filepath.Join(t.TempDir(), "simple.db")
+	assert.Nil(t, os.WriteFile(db, data, 0644))
+	csv := filepath.Join(t.TempDir(), "person.csv")
+	assert.Nil(t, os.WriteFile(csv, []byte("id,name,hp\n5,piyo,50\n6,x\n"), 0644))
+
+	status, out, _ := runCommand("import", db, "person", csv)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "rejected line 3: expected 3 fields, not 2\ninserted 1 rows into person\n", out)
+
+	_, out, _ = runCommand("tables", db)
+	assert.Equal(t, "person\t5\n", out)
+}
+
+func TestImportWAL(t *testing.T) {
+	data, err := os.ReadFile("../../testdata/simple.db")
+	assert.Nil(t, err)
+	// read and write format versions 2 put the database in WAL mode
+	data[18], data[19] = 2, 2
+	db := filepath.Join(t.TempDir(), "simple.db")
+	assert.Nil(t, os.WriteFile(db, data, 0644))
+	csv := filepath.Join(t.TempDir(), "person.csv")
+	rows := "id,name,hp\n"
+	for i := 5; i < 3000; i++ {
+		rows += fmt.Sprintf("%d,person-%d,%d\n", i, i, i)
+	}
+	assert.Nil(t, os.WriteFile(csv, []byte(rows), 0644))
+
+	status, out, _ := runCommand("import", db, "person", csv)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "inserted 2995 rows into person\n", out)
+	_, err = os.Stat(db + "-wal")
+	assert.Nil(t, err)
+
+	// sqlite3 checks the database and stores the result in a table
+	script, _ := filepath.Abs("../../script/sqlite.rb")
+	for _, q := range []string{
+		"CREATE TABLE verified(result);",
+		"INSERT INTO verified SELECT group_concat(integrity_check) FROM pragma_integrity_check;",
+	} {
+		assert.Nil(t, exec.Command("ruby", script, db, q).Run())
+	}
+	_, out, _ = runCommand("dump", db, "verified")
+	assert.Equal(t, "ok\n", out)
+
+	_, out, _ = runCommand("tables", db)
+	assert.Equal(t, "person\t2999\nverified\t1\n", out)
+}
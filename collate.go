@@ -0,0 +1,274 @@
+package sqlite3utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Collation compares two strings like a collating sequence of SQLite,
+// returning a negative number, zero or a positive number.
+type Collation func(a, b string) int
+
+var (
+	collationsMu sync.RWMutex
+	collations   = map[string]Collation{
+		"BINARY": strings.Compare,
+		"NOCASE": compareNoCase,
+		"RTRIM":  compareRTrim,
+	}
+)
+
+// RegisterCollation makes a collating sequence available to indexes and
+// sorting by name, like sqlite3_create_collation. Names are not case
+// sensitive, and registering a name again replaces the collation.
+func RegisterCollation(name string, collation Collation) {
+	collationsMu.Lock()
+	defer collationsMu.Unlock()
+	collations[strings.ToUpper(name)] = collation
+}
+
+// LookupCollation returns the collating sequence of the given name. An
+// empty name is BINARY.
+func LookupCollation(name string) (Collation, bool) {
+	if name == "" {
+		name = "BINARY"
+	}
+	collationsMu.RLock()
+	defer collationsMu.RUnlock()
+	c, ok := collations[strings.ToUpper(name)]
+	return c, ok
+}
+
+// compareNoCase folds ASCII letters to lower case only, as SQLite does.
+// sqlite3/src/main.c:nocaseCollatingFunc
+func compareNoCase(a, b string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		x, y := lowerASCII(a[i]), lowerASCII(b[i])
+		if x != y {
+			return int(x) - int(y)
+		}
+	}
+	return len(a) - len(b)
+}
+
+func lowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// compareRTrim ignores trailing spaces.
+func compareRTrim(a, b string) int {
+	return strings.Compare(strings.TrimRight(a, " "), strings.TrimRight(b, " "))
+}
+
+// compareCollated compares two values, text with the collation.
+func compareCollated(a, b *Data, collation Collation, encoding int) int {
+	if collation == nil || !a.isText() || !b.isText() {
+		return compareData(a, b)
+	}
+	return collation(a.text(encoding), b.text(encoding))
+}
+
+// keyInfo tells how the fields of records compare, like KeyInfo in
+// SQLite. Fields beyond desc and collations sort ascending with BINARY.
+type keyInfo struct {
+	desc       []bool
+	collations []Collation // nil for BINARY
+	encoding   int
+}
+
+func (k *keyInfo) compareField(i int, a, b *Data) int {
+	var collation Collation
+	if i < len(k.collations) {
+		collation = k.collations[i]
+	}
+	r := compareCollated(a, b, collation, k.encoding)
+	if i < len(k.desc) && k.desc[i] {
+		return -r
+	}
+	return r
+}
+
+// compare compares two records field by field; a record that is a
+// prefix of the other sorts first.
+func (k *keyInfo) compare(a, b []*Data) int {
+	if r := k.comparePrefix(a, b); r != 0 {
+		return r
+	}
+	if len(a) < len(b) {
+		return -1
+	} else if len(a) > len(b) {
+		return 1
+	}
+	return 0
+}
+
+// comparePrefix compares the fields both records have.
+func (k *keyInfo) comparePrefix(a, b []*Data) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if r := k.compareField(i, a[i], b[i]); r != 0 {
+			return r
+		}
+	}
+	return 0
+}
+
+// indexKeyInfo returns how the records of an index on table compare.
+// Columns without a COLLATE clause use the collation of the table
+// column.
+func indexKeyInfo(index, table *Schema, encoding int) (*keyInfo, error) {
+	k := &keyInfo{encoding: encoding}
+	for _, column := range index.Columns {
+		name := column.Collate
+		if name == "" && table != nil && table.Column(column.Name) >= 0 {
+			name = table.Columns[table.Column(column.Name)].Collate
+		}
+		collation, ok := LookupCollation(name)
+		if !ok {
+			return nil, fmt.Errorf("No such collation sequence: %s", name)
+		}
+		k.desc = append(k.desc, column.Desc)
+		k.collations = append(k.collations, collation)
+	}
+	return k, nil
+}
+
+// SeekIndex returns the rowids of the entries of an index whose leading
+// columns equal key, in index order. Key values are nil, int, int64,
+// float64, string or []byte, and are compared with the collations of
+// the index, so that a COLLATE NOCASE index finds "abc" for "ABC".
+func (s *Storage) SeekIndex(name string, key ...interface{}) ([]int64, error) {
+	index := s.Schema(name)
+	if index == nil || index.Type != "index" {
+		return nil, fmt.Errorf("No such index: %s", name)
+	}
+	table := s.Schema(index.TableName)
+	if index.SQL == "" || table == nil || table.WithoutRowid {
+		return nil, unsupportedf("seeking automatic index or index of WITHOUT ROWID table %s", name)
+	}
+	if len(key) > len(index.Columns) {
+		return nil, fmt.Errorf("Index %s has %d columns, not %d", name, len(index.Columns), len(key))
+	}
+	k, err := indexKeyInfo(index, table, s.Header.encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	datas := []*Data{}
+	for i, v := range key {
+		if n, ok := v.(int); ok {
+			v = int64(n)
+		}
+		if n := table.Column(index.Columns[i].Name); n >= 0 {
+			v = applyAffinity(v, table.Columns[n].Affinity())
+		}
+		datas = append(datas, valueData(v, s.Header))
+	}
+
+	rowids := []int64{}
+	s.seekPage(index.RootPage, k, datas, &rowids, map[int]bool{})
+	return rowids, nil
+}
+
+// seekPage adds the rowids of the matching entries in the subtree of the
+// page and reports whether entries after the matches were reached.
+func (s *Storage) seekPage(pageNum int, k *keyInfo, key []*Data, rowids *[]int64, visited map[int]bool) bool {
+	if pageNum < 1 || pageNum > len(s.Pages) || visited[pageNum] {
+		return true
+	}
+	visited[pageNum] = true
+	page := s.Pages[pageNum-1]
+
+	for _, row := range page.rows {
+		r := k.comparePrefix(key, row.datas)
+		if page.pageType == interiorIndex && r <= 0 {
+			// the left subtree holds keys up to the one of the cell
+			if s.seekPage(row.childPageNumber, k, key, rowids, visited) {
+				return true
+			}
+		}
+		if r < 0 {
+			return true
+		}
+		if r == 0 && len(row.datas) > 0 {
+			*rowids = append(*rowids, row.datas[len(row.datas)-1].int64())
+		}
+	}
+	if page.pageType == interiorIndex {
+		return s.seekPage(page.rightPtr, k, key, rowids, visited)
+	}
+	return false
+}
+
+// OrderBy returns the rows of a table sorted by the given terms, each a
+// column name optionally followed by COLLATE and ASC or DESC, as in an
+// ORDER BY clause. Columns without COLLATE use their declared collation.
+func (s *Storage) OrderBy(table string, terms ...string) ([]*Entry, error) {
+	schema, err := s.exportTable(table)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := []int{}
+	k := &keyInfo{encoding: s.Header.encoding}
+	for _, term := range terms {
+		tokens := tokenize(term)
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("Empty ORDER BY term")
+		}
+		n := schema.Column(tokens[0].text)
+		if n < 0 && !strings.EqualFold(tokens[0].text, "rowid") {
+			return nil, fmt.Errorf("No such column: %s", tokens[0].text)
+		}
+		name, desc := "", false
+		if n >= 0 {
+			name = schema.Columns[n].Collate
+		}
+		for i := 1; i < len(tokens); i++ {
+			switch {
+			case tokens[i].is("collate") && i+1 < len(tokens):
+				name = tokens[i+1].text
+				i++
+			case tokens[i].is("desc"):
+				desc = true
+			case tokens[i].is("asc"):
+			default:
+				return nil, fmt.Errorf("Invalid ORDER BY term: %s", term)
+			}
+		}
+		collation, ok := LookupCollation(name)
+		if !ok {
+			return nil, fmt.Errorf("No such collation sequence: %s", name)
+		}
+		columns = append(columns, n)
+		k.desc = append(k.desc, desc)
+		k.collations = append(k.collations, collation)
+	}
+
+	rowidColumn := schema.RowidColumn()
+	key := func(e *Entry) []*Data {
+		ret := []*Data{}
+		for _, n := range columns {
+			if n < 0 {
+				ret = append(ret, intData(int64(e.Rowid)))
+			} else {
+				ret = append(ret, field(e, n, rowidColumn))
+			}
+		}
+		return ret
+	}
+
+	entries := append([]*Entry{}, s.tableRows(schema)...)
+	keys := map[*Entry][]*Data{}
+	for _, e := range entries {
+		keys[e] = key(e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return k.compare(keys[entries[i]], keys[entries[j]]) < 0
+	})
+	return entries, nil
+}
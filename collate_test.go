@@ -0,0 +1,133 @@
+package sqlite3utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollations(t *testing.T) {
+	nocase, ok := LookupCollation("nocase")
+	assert.True(t, ok)
+	assert.Equal(t, 0, nocase("Hello", "hELLO"))
+	assert.True(t, nocase("a", "B") < 0)
+	assert.True(t, nocase("abc", "AB") > 0)
+	// only ASCII letters fold
+	assert.NotEqual(t, 0, nocase("É", "é"))
+
+	rtrim, _ := LookupCollation("RTRIM")
+	assert.Equal(t, 0, rtrim("abc  ", "abc"))
+	assert.True(t, rtrim(" abc", "abc") < 0)
+
+	binary, ok := LookupCollation("")
+	assert.True(t, ok)
+	assert.True(t, binary("B", "a") < 0)
+
+	_, ok = LookupCollation("no_such_collation")
+	assert.False(t, ok)
+}
+
+func TestSeekIndex(t *testing.T) {
+	filename := "/tmp/test_collate.db"
+	rmSQLite(filename)
+	statements := []string{
+		"PRAGMA page_size = 512; CREATE TABLE word(w TEXT COLLATE NOCASE, pad TEXT, n INTEGER);",
+		"CREATE INDEX word_w ON word(w);",
+		"CREATE INDEX word_pad ON word(pad COLLATE RTRIM, n DESC);",
+		"CREATE INDEX word_n ON word(n);",
+	}
+	for i := 0; i < 300; i++ {
+		// words differing only in case, spread over several pages
+		w := fmt.Sprintf("word%03d", i%100)
+		if i%2 == 1 {
+			w = strings.ToUpper(w)
+		}
+		statements = append(statements, fmt.Sprintf(
+			"INSERT INTO word VALUES (\"%s\", \"pad%d%s\", %d);", w, i%3, strings.Repeat(" ", i%4), i))
+	}
+	execSQLite(filename, statements)
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	// the order of the NOCASE index is checked now
+	assert.Empty(t, Check(storage))
+
+	rowids, err := storage.SeekIndex("word_w", "WoRd042")
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{43, 143, 243}, rowids)
+
+	rowids, err = storage.SeekIndex("word_w", "word1000")
+	assert.Nil(t, err)
+	assert.Empty(t, rowids)
+
+	// trailing spaces are ignored, n is descending
+	rowids, err = storage.SeekIndex("word_pad", "pad1   ", 100)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{101}, rowids)
+	rowids, err = storage.SeekIndex("word_pad", "pad2")
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(rowids))
+	assert.Equal(t, int64(300), rowids[0])
+
+	// affinity turns the text into an integer
+	rowids, err = storage.SeekIndex("word_n", "7")
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{8}, rowids)
+
+	_, err = storage.SeekIndex("word", "x")
+	assert.NotNil(t, err)
+	_, err = storage.SeekIndex("word_n", 1, 2)
+	assert.NotNil(t, err)
+
+	rmSQLite(filename)
+}
+
+func TestOrderBy(t *testing.T) {
+	filename := "/tmp/test_order_by.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"CREATE TABLE item(id INTEGER PRIMARY KEY, name TEXT COLLATE NOCASE, code TEXT);",
+		"INSERT INTO item VALUES (1, \"banana\", \"b\"), (2, \"Apple\", \"A\"), (3, \"cherry\", \"c\"), (4, \"apple\", \"a\");",
+	})
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+
+	rowids := func(entries []*Entry) []uint64 {
+		ret := []uint64{}
+		for _, e := range entries {
+			ret = append(ret, e.Rowid)
+		}
+		return ret
+	}
+
+	entries, err := storage.OrderBy("item", "name")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{2, 4, 1, 3}, rowids(entries))
+
+	entries, err = storage.OrderBy("item", "name COLLATE BINARY", "id DESC")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{2, 4, 1, 3}, rowids(entries))
+
+	entries, err = storage.OrderBy("item", "name", "rowid DESC")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{4, 2, 1, 3}, rowids(entries))
+
+	_, err = storage.OrderBy("item", "code COLLATE reverse_test")
+	assert.NotNil(t, err)
+
+	RegisterCollation("reverse_test", func(a, b string) int {
+		return strings.Compare(b, a)
+	})
+	entries, err = storage.OrderBy("item", "code COLLATE reverse_test")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{3, 1, 4, 2}, rowids(entries))
+
+	_, err = storage.OrderBy("item", "nothing")
+	assert.NotNil(t, err)
+	_, err = storage.OrderBy("item", "name sideways")
+	assert.NotNil(t, err)
+
+	rmSQLite(filename)
+}
@@ -0,0 +1,224 @@
+package sqlite3utils
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// concurrencyDB makes a database with a few tables and indexes spanning
+// several pages.
+func concurrencyDB(filename string) {
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"PRAGMA page_size = 512; CREATE TABLE item(id INTEGER PRIMARY KEY, name TEXT COLLATE NOCASE, price REAL, data BLOB);",
+		"CREATE INDEX item_name ON item(name);",
+		"CREATE TABLE tag(name TEXT PRIMARY KEY, n) WITHOUT ROWID;",
+		"CREATE TABLE note(body);",
+		"WITH RECURSIVE c(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM c WHERE i < 1000) INSERT INTO item SELECT i, printf(char(37, 100), i % 100), i / 3.0, zeroblob(i) FROM c;",
+		"INSERT INTO tag SELECT name, count(*) FROM item GROUP BY name;",
+		"INSERT INTO note SELECT name FROM item WHERE id <= 500;",
+	})
+}
+
+func TestConcurrentReaders(t *testing.T) {
+	filename := "/tmp/test_concurrency.db"
+	concurrencyDB(filename)
+
+	// results of every reader run alone on a storage of their own
+	readers := []func(s *Storage) interface{}{
+		func(s *Storage) interface{} { return s.InspectPages() },
+		func(s *Storage) interface{} { return Check(s) },
+		func(s *Storage) interface{} { return Analyze(s) },
+		func(s *Storage) interface{} { return s.RowCounts() },
+		func(s *Storage) interface{} {
+			var out bytes.Buffer
+			Dump(s, &out)
+			return out.String()
+		},
+		func(s *Storage) interface{} {
+			var out bytes.Buffer
+			ExportJSONLines(s, "tag", &out, JSONOptions{})
+			return out.String()
+		},
+		func(s *Storage) interface{} {
+			rowids, _ := s.SeekIndex("item_name", "42")
+			return rowids
+		},
+		func(s *Storage) interface{} {
+			entries, _ := s.OrderBy("item", "name", "id DESC")
+			rowids := []uint64{}
+			for _, e := range entries {
+				rowids = append(rowids, e.Rowid)
+			}
+			return rowids
+		},
+		func(s *Storage) interface{} {
+			b, _ := s.OpenBlob("item", "data", 900)
+			data, _ := ioutil.ReadAll(b)
+			return len(data)
+		},
+	}
+	want := []interface{}{}
+	for _, reader := range readers {
+		storage, err := Load(filename)
+		assert.Nil(t, err)
+		want = append(want, reader(storage))
+	}
+
+	for _, options := range []LoadOptions{{}, {Lazy: true}} {
+		storage, err := LoadWithOptions(filename, options)
+		assert.Nil(t, err)
+
+		var wg sync.WaitGroup
+		got := make([][]interface{}, 4)
+		for g := range got {
+			got[g] = make([]interface{}, len(readers))
+			for i, reader := range readers {
+				wg.Add(1)
+				go func(g, i int, reader func(s *Storage) interface{}) {
+					defer wg.Done()
+					got[g][i] = reader(storage)
+				}(g, i, reader)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for _, e := range storage.Tables["item"].Entries {
+					e.Field(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		for g := range got {
+			for i := range readers {
+				assert.Equal(t, want[i], got[g][i], "reader %d", i)
+			}
+		}
+	}
+
+	rmSQLite(filename)
+}
+
+func TestLoadParallelTrees(t *testing.T) {
+	filename := "/tmp/test_parallel_trees.db"
+	concurrencyDB(filename)
+
+	want, err := Load(filename)
+	assert.Nil(t, err)
+	got, err := LoadWithOptions(filename, LoadOptions{ParallelTrees: true})
+	assert.Nil(t, err)
+	assert.Equal(t, want.Pages, got.Pages)
+	assert.Equal(t, len(want.Tables), len(got.Tables))
+	for name, table := range want.Tables {
+		assert.Equal(t, len(table.Entries), len(got.Tables[name].Entries))
+		for i, e := range table.Entries {
+			assert.Equal(t, e.Datas, got.Tables[name].Entries[i].Datas)
+		}
+	}
+
+	// break the first cell of an interior page and of leaf pages
+	cnt, _ := ioutil.ReadFile(filename)
+	pageSize := want.Header.pageSize
+	broken := 0
+	for _, page := range want.Pages[1:] {
+		if broken == 3 && page.pageType != interiorTable || broken > 3 {
+			continue
+		}
+		offset := pageSize*(page.pageNum-1) + 8
+		switch page.pageType {
+		case interiorTable:
+			offset += 4
+		case leafTable, leafIndex:
+		default:
+			continue
+		}
+		cnt[offset] = 0xff
+		cnt[offset+1] = 0xff
+		broken++
+	}
+	ioutil.WriteFile(filename, cnt, 0644)
+
+	_, err = LoadWithOptions(filename, LoadOptions{ParallelTrees: true})
+	assert.NotNil(t, err)
+	want, err = LoadWithOptions(filename, LoadOptions{Recover: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(want.Diagnostics))
+	got, err = LoadWithOptions(filename, LoadOptions{Recover: true, ParallelTrees: true})
+	assert.Nil(t, err)
+	assert.Equal(t, want.Diagnostics, got.Diagnostics)
+	assert.Equal(t, want.Pages, got.Pages)
+
+	rmSQLite(filename)
+}
+
+func TestLoadWorkers(t *testing.T) {
+	filename := "/tmp/test_load_workers.db"
+	concurrencyDB(filename)
+
+	want, err := Load(filename)
+	assert.Nil(t, err)
+	for _, workers := range []int{2, 3, 8, 1000} {
+		got, err := LoadWithOptions(filename, LoadOptions{Workers: workers})
+		assert.Nil(t, err)
+		assert.Equal(t, want.Pages, got.Pages)
+		assert.Equal(t, want.Schemas, got.Schemas)
+		for name, table := range want.Tables {
+			assert.Equal(t, len(table.Entries), len(got.Tables[name].Entries))
+			for i, e := range table.Entries {
+				assert.Equal(t, e.Datas, got.Tables[name].Entries[i].Datas)
+			}
+		}
+	}
+
+	// break the first cell of several pages
+	cnt, _ := ioutil.ReadFile(filename)
+	pageSize := want.Header.pageSize
+	for _, pageNum := range []int{5, 9, 30, 31} {
+		offset := pageSize*(pageNum-1) + 8
+		if want.Pages[pageNum-1].pageType == interiorTable || want.Pages[pageNum-1].pageType == interiorIndex {
+			offset += 4
+		}
+		cnt[offset] = 0xff
+		cnt[offset+1] = 0xff
+	}
+	ioutil.WriteFile(filename, cnt, 0644)
+
+	_, err = LoadWithOptions(filename, LoadOptions{Workers: 4})
+	assert.NotNil(t, err)
+	want, err = LoadWithOptions(filename, LoadOptions{Recover: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(want.Diagnostics))
+	got, err := LoadWithOptions(filename, LoadOptions{Recover: true, Workers: 4})
+	assert.Nil(t, err)
+	assert.Equal(t, want.Diagnostics, got.Diagnostics)
+	assert.Equal(t, len(want.Tables["item"].Entries), len(got.Tables["item"].Entries))
+
+	rmSQLite(filename)
+}
+
+func BenchmarkLoadWorkers(b *testing.B) {
+	filename := "/tmp/bench_load_workers.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"CREATE TABLE item(id INTEGER PRIMARY KEY, name TEXT, price REAL, data BLOB);",
+		"WITH RECURSIVE c(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM c WHERE i < 200000) INSERT INTO item SELECT i, printf(char(37, 48, 51, 48, 100), i), i / 7.0, randomblob(i % 64) FROM c;",
+		"CREATE INDEX item_name ON item(name);",
+	})
+	defer rmSQLite(filename)
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				if _, err := LoadWithOptions(filename, LoadOptions{Workers: workers}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package sqlite3utils
+
+// Count returns the number of rows of the table, adding up the cell
+// counts of the leaf pages of its b-tree instead of decoding the rows.
+func (t *Table) Count() int {
+	if t.storage == nil || t.root == 0 {
+		return len(t.Entries)
+	}
+	return t.storage.countCells(t.root, map[int]bool{})
+}
+
+// RowCount is the number of rows of a table or of keys of an index.
+type RowCount struct {
+	Name      string
+	Type      string // "table" or "index"
+	TableName string
+	Rows      int
+}
+
+// RowCounts counts the rows of sqlite_master and every table and index
+// from the headers of their b-tree pages, without decoding any cell but
+// the child pointers of interior pages. Objects without a b-tree, like
+// views and virtual tables, are left out.
+func (s *Storage) RowCounts() []*RowCount {
+	ret := []*RowCount{}
+	for _, schema := range append([]*Schema{sqliteMaster()}, s.Schemas...) {
+		if schema.RootPage < 1 || schema.RootPage > len(s.Pages) {
+			continue
+		}
+		ret = append(ret, &RowCount{
+			Name:      schema.Name,
+			Type:      schema.Type,
+			TableName: schema.TableName,
+			Rows:      s.countCells(schema.RootPage, map[int]bool{}),
+		})
+	}
+	return ret
+}
+
+// countCells returns the number of entries in the b-tree below a page:
+// the cells of the leaves of a table b-tree, and all the cells of an
+// index b-tree, whose interior cells hold keys too.
+func (s *Storage) countCells(pageNum int, visited map[int]bool) int {
+	pageSize := s.Header.pageSize
+	if pageNum < 1 || pageSize*pageNum > len(s.cnt) || visited[pageNum] {
+		return 0
+	}
+	visited[pageNum] = true
+
+	offset := pageSize * (pageNum - 1)
+	if pageNum == 1 {
+		offset = 100
+	}
+	pageType := fetchInt(s.cnt, offset, 1)
+	count := fetchInt(s.cnt, offset+3, 2)
+
+	switch pageType {
+	case leafTable, leafIndex:
+		return count
+	case interiorTable, interiorIndex:
+	default:
+		return 0
+	}
+
+	ret := 0
+	if pageType == interiorIndex {
+		ret = count
+	}
+	start := pageSize * (pageNum - 1)
+	for i := 0; i < count; i++ {
+		ptr := offset + 12 + 2*i
+		if ptr+2 > start+pageSize {
+			break
+		}
+		cell := start + fetchInt(s.cnt, ptr, 2)
+		if cell+4 > start+pageSize {
+			continue
+		}
+		ret += s.countCells(fetchInt(s.cnt, cell, 4), visited)
+	}
+	return ret + s.countCells(fetchInt(s.cnt, offset+8, 4), visited)
+}
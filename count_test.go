@@ -0,0 +1,45 @@
+package sqlite3utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCount(t *testing.T) {
+	filename := "/tmp/test_count.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"PRAGMA page_size = 512; CREATE TABLE item(id INTEGER PRIMARY KEY, name TEXT);",
+		"CREATE INDEX item_name ON item(name);",
+		"CREATE TABLE tag(name TEXT PRIMARY KEY, n) WITHOUT ROWID;",
+		"CREATE VIEW item_view AS SELECT * FROM item;",
+		"CREATE TABLE empty(x);",
+		"WITH RECURSIVE c(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM c WHERE i < 3000) INSERT INTO item SELECT i, printf(char(37, 48, 50, 48, 100), i) FROM c;",
+		"INSERT INTO tag SELECT name, id FROM item WHERE id <= 1000;",
+		"DELETE FROM item WHERE id % 7 = 0;",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+
+	item := storage.Tables["item"]
+	assert.Equal(t, 2572, item.Count())
+	assert.Equal(t, len(item.Entries), item.Count())
+	assert.Equal(t, 0, storage.Tables["empty"].Count())
+	assert.Equal(t, 5, storage.Tables["sqlite_master"].Count())
+
+	counts := map[string]int{}
+	for _, c := range storage.RowCounts() {
+		counts[c.Name] = c.Rows
+	}
+	assert.Equal(t, map[string]int{
+		"sqlite_master": 5,
+		"item":          2572,
+		"item_name":     2572,
+		"tag":           1000,
+		"empty":         0,
+	}, counts)
+
+	rmSQLite(filename)
+}
@@ -0,0 +1,249 @@
+package sqlite3utils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// sqlite3/tool/mkkeywordhash.c
+var sqlKeywords = map[string]bool{}
+
+func init() {
+	for _, k := range strings.Fields(`ABORT ACTION ADD AFTER ALL ALTER ALWAYS ANALYZE AND AS ASC
+		ATTACH AUTOINCREMENT BEFORE BEGIN BETWEEN BY CASCADE CASE CAST CHECK COLLATE COLUMN
+		COMMIT CONFLICT CONSTRAINT CREATE CROSS CURRENT CURRENT_DATE CURRENT_TIME
+		CURRENT_TIMESTAMP DATABASE DEFAULT DEFERRABLE DEFERRED DELETE DESC DETACH DISTINCT
+		DO DROP EACH ELSE END ESCAPE EXCEPT EXCLUDE EXCLUSIVE EXISTS EXPLAIN FAIL FILTER
+		FIRST FOLLOWING FOR FOREIGN FROM FULL GENERATED GLOB GROUP GROUPS HAVING IF IGNORE
+		IMMEDIATE IN INDEX INDEXED INITIALLY INNER INSERT INSTEAD INTERSECT INTO IS ISNULL
+		JOIN KEY LAST LEFT LIKE LIMIT MATCH MATERIALIZED NATURAL NO NOT NOTHING NOTNULL NULL
+		NULLS OF OFFSET ON OR ORDER OTHERS OUTER OVER PARTITION PLAN PRAGMA PRECEDING PRIMARY
+		QUERY RAISE RANGE RECURSIVE REFERENCES REGEXP REINDEX RELEASE RENAME REPLACE RESTRICT
+		RETURNING RIGHT ROLLBACK ROW ROWS SAVEPOINT SELECT SET TABLE TEMP TEMPORARY THEN TIES
+		TO TRANSACTION TRIGGER UNBOUNDED UNION UNIQUE UPDATE USING VACUUM VALUES VIEW VIRTUAL
+		WHEN WHERE WINDOW WITH WITHOUT`) {
+		sqlKeywords[k] = true
+	}
+}
+
+// quoteIdent quotes a name for SQL unless it is a plain identifier.
+func quoteIdent(name string) string {
+	plain := name != "" && !sqlKeywords[strings.ToUpper(name)]
+	for i, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			plain = false
+		}
+	}
+	if plain {
+		return name
+	}
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// text returns the value of a text field as UTF-8, decoding UTF-16 for
+// databases in that encoding.
+func (d *Data) text(encoding int) string {
+	if encoding != 2 && encoding != 3 {
+		return string(d.Bytes)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if encoding == 3 {
+		order = binary.BigEndian
+	}
+	units := make([]uint16, len(d.Bytes)/2)
+	for i := range units {
+		units[i] = order.Uint16(d.Bytes[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// formatReal formats a float as an SQL literal that reads back as the
+// same REAL.
+func formatReal(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "9.0e+999"
+	case math.IsInf(f, -1):
+		return "-9.0e+999"
+	case f == 0:
+		return "0.0"
+	}
+	return FormatReal(f, RealShortest)
+}
+
+// sqlLiteral returns the field as an SQL literal.
+func sqlLiteral(d *Data, encoding int) string {
+	switch {
+	case d.isNull():
+		return "NULL"
+	case d.isInt():
+		return strconv.FormatInt(d.int64(), 10)
+	case d.isFloat():
+		f := d.float64()
+		if math.IsNaN(f) {
+			return "NULL"
+		}
+		return formatReal(f)
+	case d.isBlob():
+		return "X'" + hex.EncodeToString(d.Bytes) + "'"
+	}
+
+	text := d.text(encoding)
+	if !strings.ContainsAny(text, "\r\n") {
+		return sqlQuote(text)
+	}
+
+	// line breaks become markers that replace() turns back
+	cr := unusedString(text, `\r`, `\015`)
+	nl := unusedString(text, `\n`, `\012`)
+	text = strings.NewReplacer("\r", cr, "\n", nl).Replace(text)
+	return fmt.Sprintf("replace(replace(%s,%s,char(13)),%s,char(10))", sqlQuote(text), sqlQuote(cr), sqlQuote(nl))
+}
+
+// unusedString returns the first candidate, or a numbered variant of
+// it, that does not occur in text.
+// sqlite3/src/shell.c.in:unused_string
+func unusedString(text, candidate, alternative string) string {
+	if !strings.Contains(text, candidate) {
+		return candidate
+	}
+	if !strings.Contains(text, alternative) {
+		return alternative
+	}
+	for i := 0; ; i++ {
+		s := fmt.Sprintf("(%s%d)", candidate, i)
+		if !strings.Contains(text, s) {
+			return s
+		}
+	}
+}
+
+// tableRows returns the rows of a table in key order, the fields of a
+// WITHOUT ROWID table rearranged into declaration order.
+func (s *Storage) tableRows(schema *Schema) []*Entry {
+	if !schema.WithoutRowid {
+		if table, ok := s.Tables[schema.Name]; ok {
+			return table.Entries
+		}
+		return []*Entry{}
+	}
+
+	// the record holds the primary key columns first
+	order := append([]int{}, schema.PrimaryKey...)
+	for i, column := range schema.Columns {
+		if !column.PrimaryKey {
+			order = append(order, i)
+		}
+	}
+
+	entries := []*Entry{}
+	for _, e := range newChecker(s).checkTree(schema.RootPage, schema.Name, false, nil) {
+		datas := make([]*Data, len(schema.Columns))
+		for i, n := range order {
+			if i < len(e.record) {
+				datas[n] = e.record[i]
+			} else {
+				datas[n] = nullData()
+			}
+		}
+		entries = append(entries, &Entry{Datas: datas})
+	}
+	return entries
+}
+
+// Dump writes the database as SQL text in the format of the .dump
+// command of sqlite3, so that piping it into sqlite3 recreates the
+// database. Virtual tables are written into sqlite_master without rows.
+// Objects come in the order of sqlite3: the tables in sqlite_master
+// order with sqlite_sequence last, then the views, the triggers and the
+// indexes, each in sqlite_master order.
+func Dump(storage *Storage, out io.Writer) error {
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n")
+
+	tables := []*Schema{}
+	others := []*Schema{}
+	for _, schema := range storage.Schemas {
+		if schema.SQL == "" {
+			continue
+		}
+		if schema.Type == "table" {
+			tables = append(tables, schema)
+		} else {
+			others = append(others, schema)
+		}
+	}
+	sort.SliceStable(tables, func(i, j int) bool {
+		return tables[i].Name != "sqlite_sequence" && tables[j].Name == "sqlite_sequence"
+	})
+	// views, then triggers, then indexes, like ORDER BY type DESC
+	sort.SliceStable(others, func(i, j int) bool {
+		return strings.ToLower(others[i].Type) > strings.ToLower(others[j].Type)
+	})
+
+	writableSchema := false
+	for _, schema := range tables {
+		name := schema.Name
+		switch {
+		case name == "sqlite_sequence":
+			// AUTOINCREMENT inserts above already filled it
+			fmt.Fprintf(w, "DELETE FROM sqlite_sequence;\n")
+		case strings.HasPrefix(name, "sqlite_stat"):
+			fmt.Fprintf(w, "ANALYZE sqlite_schema;\n")
+		case strings.HasPrefix(name, "sqlite_"):
+			continue
+		case strings.HasPrefix(strings.ToUpper(schema.SQL), "CREATE VIRTUAL TABLE"):
+			if !writableSchema {
+				fmt.Fprintf(w, "PRAGMA writable_schema=ON;\n")
+				writableSchema = true
+			}
+			fmt.Fprintf(w, "INSERT INTO sqlite_schema(type,name,tbl_name,rootpage,sql)VALUES('table',%s,%s,0,%s);\n",
+				sqlQuote(name), sqlQuote(name), sqlQuote(schema.SQL))
+			continue
+		case strings.HasPrefix(schema.SQL, `CREATE TABLE "`) || strings.HasPrefix(schema.SQL, "CREATE TABLE '"):
+			fmt.Fprintf(w, "CREATE TABLE IF NOT EXISTS %s;\n", schema.SQL[len("CREATE TABLE "):])
+		default:
+			fmt.Fprintf(w, "%s;\n", schema.SQL)
+		}
+
+		rowidColumn := schema.RowidColumn()
+		for _, e := range storage.tableRows(schema) {
+			values := []string{}
+			for i, column := range schema.Columns {
+				switch {
+				case i == rowidColumn:
+					values = append(values, strconv.FormatInt(int64(e.Rowid), 10))
+				case i < e.Len() && e.Field(i).isInt() && column.Affinity() == AffinityReal:
+					// REAL values without a fraction are stored as integers
+					values = append(values, formatReal(e.Field(i).float64()))
+				case i < e.Len():
+					values = append(values, sqlLiteral(e.Field(i), storage.Header.encoding))
+				default:
+					values = append(values, "NULL")
+				}
+			}
+			fmt.Fprintf(w, "INSERT INTO %s VALUES(%s);\n", quoteIdent(name), strings.Join(values, ","))
+		}
+	}
+
+	for _, schema := range others {
+		fmt.Fprintf(w, "%s;\n", schema.SQL)
+	}
+	if writableSchema {
+		fmt.Fprintf(w, "PRAGMA writable_schema=OFF;\n")
+	}
+	fmt.Fprintf(w, "COMMIT;\n")
+	return w.Flush()
+}
+
+func sqlQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
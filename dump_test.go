@@ -0,0 +1,115 @@
+package sqlite3utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func dumpString(t *testing.T, filename string) string {
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	out := &bytes.Buffer{}
+	assert.Nil(t, Dump(storage, out))
+	return out.String()
+}
+
+func TestDump(t *testing.T) {
+	filename := "/tmp/test_dump.db"
+	restored := "/tmp/test_dump_restored.db"
+	script := "/tmp/test_dump.sql"
+	rmSQLite(filename)
+	rmSQLite(restored)
+	execSQLite(filename, []string{
+		"CREATE TABLE \"my t\"(id integer primary key autoincrement, name text, b blob, f real);",
+		"INSERT INTO \"my t\"(name, b, f) VALUES (\"it\" || char(39) || \"s\", CAST(\"ab\" AS BLOB), 1.5), (\"a\" || char(10) || \"b\", NULL, 100.0), (NULL, zeroblob(0), 0.1), (\"x\", 1, 1e300);",
+		"CREATE INDEX i ON \"my t\"(name); CREATE VIEW v AS SELECT 1;",
+		"CREATE TABLE w(k text, v, n integer, PRIMARY KEY(n, k)) WITHOUT ROWID; INSERT INTO w VALUES (\"b\", 2, 1), (\"a\", 1, 1), (\"c\", 3, 0);",
+		"CREATE TRIGGER tr AFTER INSERT ON w BEGIN SELECT 1; END;",
+		"CREATE TABLE z(a); INSERT INTO z VALUES (9223372036854775807), (-5), (1e20), (-0.0);",
+		"ALTER TABLE z ADD COLUMN c DEFAULT 7; INSERT INTO z VALUES (1, 2);",
+	})
+
+	dump := dumpString(t, filename)
+	assert.Equal(t, strings.Join([]string{
+		"PRAGMA foreign_keys=OFF;",
+		"BEGIN TRANSACTION;",
+		"CREATE TABLE IF NOT EXISTS \"my t\"(id integer primary key autoincrement, name text, b blob, f real);",
+		"INSERT INTO \"my t\" VALUES(1,'it''s',X'6162',1.5);",
+		"INSERT INTO \"my t\" VALUES(2,replace(replace('a\\nb','\\r',char(13)),'\\n',char(10)),NULL,100.0);",
+		"INSERT INTO \"my t\" VALUES(3,NULL,X'',0.1);",
+		"INSERT INTO \"my t\" VALUES(4,'x',1,1.0e+300);",
+		"CREATE TABLE w(k text, v, n integer, PRIMARY KEY(n, k)) WITHOUT ROWID;",
+		"INSERT INTO w VALUES('c',3,0);",
+		"INSERT INTO w VALUES('a',1,1);",
+		"INSERT INTO w VALUES('b',2,1);",
+		"CREATE TABLE z(a, c DEFAULT 7);",
+		"INSERT INTO z VALUES(9223372036854775807,7);",
+		"INSERT INTO z VALUES(-5,7);",
+		"INSERT INTO z VALUES(1.0e+20,7);",
+		"INSERT INTO z VALUES(0.0,7);",
+		"INSERT INTO z VALUES(1,2);",
+		"DELETE FROM sqlite_sequence;",
+		"INSERT INTO sqlite_sequence VALUES('my t',4);",
+		"CREATE VIEW v AS SELECT 1;",
+		"CREATE TRIGGER tr AFTER INSERT ON w BEGIN SELECT 1; END;",
+		"CREATE INDEX i ON \"my t\"(name);",
+		"COMMIT;",
+		"",
+	}, "\n"), dump)
+
+	// sqlite3 reads the dump back into the same database
+	assert.Nil(t, ioutil.WriteFile(script, []byte(dump), 0644))
+	execSQLite(restored, []string{".read " + script})
+	assert.Equal(t, dump, dumpString(t, restored))
+
+	rmSQLite(filename)
+	rmSQLite(restored)
+	rmSQLite(script)
+}
+
+// createLines returns the CREATE statements of a dump.
+func createLines(dump string) []string {
+	ret := []string{}
+	for _, line := range strings.Split(dump, "\n") {
+		if strings.HasPrefix(line, "CREATE ") {
+			ret = append(ret, line)
+		}
+	}
+	return ret
+}
+
+func TestDumpOrder(t *testing.T) {
+	filename := "/tmp/test_dump_order.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"CREATE TABLE b(x); CREATE INDEX bi ON b(x); CREATE VIEW v1 AS SELECT 1;",
+		"CREATE TABLE a(y integer primary key autoincrement, z);",
+		"CREATE TRIGGER t1 AFTER INSERT ON a BEGIN SELECT 1; END; CREATE INDEX ai ON a(z);",
+		"CREATE VIEW v0 AS SELECT 2; CREATE TABLE c(w); INSERT INTO a(z) VALUES (1);",
+	})
+
+	// the objects come in the order of sqlite3 itself
+	script, _ := filepath.Abs("./script/sqlite.rb")
+	out, err := exec.Command("ruby", script, filename, ".dump").Output()
+	assert.Nil(t, err)
+	expected := createLines(string(out))
+	assert.Equal(t, []string{
+		"CREATE TABLE b(x);",
+		"CREATE TABLE a(y integer primary key autoincrement, z);",
+		"CREATE TABLE c(w);",
+		"CREATE VIEW v1 AS SELECT 1;",
+		"CREATE VIEW v0 AS SELECT 2;",
+		"CREATE TRIGGER t1 AFTER INSERT ON a BEGIN SELECT 1; END;",
+		"CREATE INDEX bi ON b(x);",
+		"CREATE INDEX ai ON a(z);",
+	}, expected)
+	assert.Equal(t, expected, createLines(dumpString(t, filename)))
+
+	rmSQLite(filename)
+}
@@ -0,0 +1,57 @@
+package sqlite3utils
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotSQLite is returned for a file without a SQLite header.
+	ErrNotSQLite = errors.New("sqlite3utils: file is not a SQLite database")
+	// ErrUnsupported is returned for databases using features this
+	// package cannot read.
+	ErrUnsupported = errors.New("sqlite3utils: unsupported database")
+)
+
+// CorruptError reports a malformed structure in the database file.
+type CorruptError struct {
+	Page   int // page number, 0 if unknown
+	Cell   int // cell index on the page, -1 if not about a cell
+	Offset int // byte offset in the file, -1 if unknown
+	Reason string
+}
+
+func (e *CorruptError) Error() string {
+	ret := "sqlite3utils: database is corrupt"
+	if e.Page > 0 {
+		ret += fmt.Sprintf(": page %d", e.Page)
+		if e.Cell >= 0 {
+			ret += fmt.Sprintf(" cell %d", e.Cell)
+		}
+	}
+	if e.Offset >= 0 {
+		ret += fmt.Sprintf(" (offset %d)", e.Offset)
+	}
+	return ret + ": " + e.Reason
+}
+
+func corruptf(page, cell, offset int, format string, args ...interface{}) *CorruptError {
+	return &CorruptError{
+		Page:   page,
+		Cell:   cell,
+		Offset: offset,
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
+
+func unsupportedf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrUnsupported}, args...)...)
+}
+
+// reason returns the message of err without the package prefix.
+func reason(err error) string {
+	if e, ok := err.(*CorruptError); ok {
+		return e.Reason
+	}
+	return err.Error()
+}
@@ -0,0 +1,65 @@
+package sqlite3utils
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrNotSQLite(t *testing.T) {
+	filename := "/tmp/test_errors.db"
+	ioutil.WriteFile(filename, []byte("hello, world"), 0644)
+
+	_, err := Load(filename)
+	assert.Equal(t, ErrNotSQLite, err)
+
+	rmSQLite(filename)
+}
+
+func TestErrUnsupported(t *testing.T) {
+	filename := "/tmp/test_errors.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{"CREATE TABLE person(id integer, name text);"})
+
+	cnt, _ := ioutil.ReadFile(filename)
+	cnt[19] = 3 // read version
+	ioutil.WriteFile(filename, cnt, 0644)
+
+	_, err := Load(filename)
+	assert.True(t, errors.Is(err, ErrUnsupported), err)
+
+	rmSQLite(filename)
+}
+
+func TestCorruptError(t *testing.T) {
+	filename := "/tmp/test_errors.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"CREATE TABLE person(id integer, name text);",
+		"INSERT INTO person VALUES (1, \"hoge\");",
+		"INSERT INTO person VALUES (2, \"foo\");",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	pageSize := storage.Header.pageSize
+
+	// Point the second cell of the person table past the end of the page.
+	cnt, _ := ioutil.ReadFile(filename)
+	cnt[pageSize+8+2] = 0xff
+	cnt[pageSize+8+3] = 0xff
+	ioutil.WriteFile(filename, cnt, 0644)
+
+	_, err = Load(filename)
+	corrupt, ok := err.(*CorruptError)
+	assert.True(t, ok, err)
+	if ok {
+		assert.Equal(t, 2, corrupt.Page)
+		assert.Equal(t, 1, corrupt.Cell)
+		assert.Equal(t, pageSize+0xffff, corrupt.Offset)
+	}
+
+	rmSQLite(filename)
+}
@@ -0,0 +1,176 @@
+package sqlite3utils
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// BlobEncoding is how blobs are written as text.
+type BlobEncoding int
+
+// Blob encodings ...
+const (
+	BlobHex BlobEncoding = iota
+	BlobBase64
+)
+
+func (e BlobEncoding) encode(bs []byte) string {
+	if e == BlobBase64 {
+		return base64.StdEncoding.EncodeToString(bs)
+	}
+	return hex.EncodeToString(bs)
+}
+
+func (e BlobEncoding) decode(s string) ([]byte, error) {
+	if e == BlobBase64 {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return hex.DecodeString(s)
+}
+
+// CSVOptions ...
+type CSVOptions struct {
+	Delimiter rune   // ',' if zero
+	Null      string // text written for NULL
+	Blob      BlobEncoding
+}
+
+// JSONOptions ...
+type JSONOptions struct {
+	Blob BlobEncoding
+}
+
+// exportTable returns the schema of a table that can be exported.
+func (s *Storage) exportTable(name string) (*Schema, error) {
+	schema := s.Schema(name)
+	if schema == nil || schema.Type != "table" {
+		return nil, fmt.Errorf("No such table: %s", name)
+	}
+	if len(schema.Columns) == 0 {
+		return nil, fmt.Errorf("No columns known for table %s", name)
+	}
+	return schema, nil
+}
+
+// field returns column i of a row: the rowid for the INTEGER PRIMARY KEY
+// and NULL for columns the record lacks.
+func field(e *Entry, i, rowidColumn int) *Data {
+	if i == rowidColumn {
+		return intData(int64(e.Rowid))
+	}
+	return e.Field(i)
+}
+
+// ExportCSV writes the rows of a table as CSV, with the column names in
+// the first line.
+func ExportCSV(storage *Storage, table string, out io.Writer, options CSVOptions) error {
+	schema, err := storage.exportTable(table)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(out)
+	if options.Delimiter != 0 {
+		w.Comma = options.Delimiter
+	}
+
+	record := []string{}
+	for _, column := range schema.Columns {
+		record = append(record, column.Name)
+	}
+	if err := w.Write(record); err != nil {
+		return err
+	}
+
+	rowidColumn := schema.RowidColumn()
+	for _, e := range storage.tableRows(schema) {
+		record = record[:0]
+		for i, column := range schema.Columns {
+			d := field(e, i, rowidColumn)
+			switch {
+			case d.isNull():
+				record = append(record, options.Null)
+			case d.isBlob():
+				record = append(record, options.Blob.encode(d.Bytes))
+			case d.isText():
+				record = append(record, d.text(storage.Header.encoding))
+			case d.isFloat() || column.Affinity() == AffinityReal:
+				record = append(record, formatReal(d.float64()))
+			default:
+				record = append(record, strconv.FormatInt(d.int64(), 10))
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// ExportJSONLines writes each row of a table as a JSON object on its own
+// line, keyed by column name in declaration order. Blobs become encoded
+// strings; infinite and NaN reals become null.
+func ExportJSONLines(storage *Storage, table string, out io.Writer, options JSONOptions) error {
+	schema, err := storage.exportTable(table)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{}
+	for _, column := range schema.Columns {
+		keys = append(keys, jsonString(column.Name))
+	}
+
+	w := bufio.NewWriter(out)
+	rowidColumn := schema.RowidColumn()
+	for _, e := range storage.tableRows(schema) {
+		w.WriteByte('{')
+		for i, column := range schema.Columns {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(keys[i])
+			w.WriteByte(':')
+
+			d := field(e, i, rowidColumn)
+			switch {
+			case d.isNull():
+				w.WriteString("null")
+			case d.isBlob():
+				w.WriteString(jsonString(options.Blob.encode(d.Bytes)))
+			case d.isText():
+				w.WriteString(jsonString(d.text(storage.Header.encoding)))
+			case d.isFloat() || column.Affinity() == AffinityReal:
+				f := d.float64()
+				if math.IsInf(f, 0) || math.IsNaN(f) {
+					w.WriteString("null")
+				} else {
+					w.WriteString(formatReal(f))
+				}
+			default:
+				w.WriteString(strconv.FormatInt(d.int64(), 10))
+			}
+		}
+		w.WriteString("}\n")
+	}
+	return w.Flush()
+}
+
+// jsonString quotes s for JSON, leaving HTML characters alone.
+func jsonString(s string) string {
+	b := &strings.Builder{}
+	encoder := json.NewEncoder(b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
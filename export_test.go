@@ -0,0 +1,45 @@
+package sqlite3utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	filename := "/tmp/test_export.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"CREATE TABLE item(id integer primary key, name text, data blob, price real, n);",
+		"INSERT INTO item VALUES (1, \"a,b\", CAST(\"hi\" AS BLOB), 1.5, -3), (2, \"<x>\" || char(10) || \"y\", NULL, 100.0, NULL);",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+
+	out := &bytes.Buffer{}
+	assert.Nil(t, ExportCSV(storage, "item", out, CSVOptions{}))
+	assert.Equal(t, "id,name,data,price,n\n1,\"a,b\",6869,1.5,-3\n2,\"<x>\ny\",,100.0,\n", out.String())
+
+	out.Reset()
+	assert.Nil(t, ExportCSV(storage, "item", out, CSVOptions{Delimiter: '\t', Null: "NULL", Blob: BlobBase64}))
+	assert.Equal(t, "id\tname\tdata\tprice\tn\n1\ta,b\taGk=\t1.5\t-3\n2\t\"<x>\ny\"\tNULL\t100.0\tNULL\n", out.String())
+
+	out.Reset()
+	assert.Nil(t, ExportJSONLines(storage, "item", out, JSONOptions{}))
+	lines := strings.Split(out.String(), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Equal(t, `{"id":1,"name":"a,b","data":"6869","price":1.5,"n":-3}`, lines[0])
+	assert.Equal(t, `{"id":2,"name":"<x>\ny","data":null,"price":100.0,"n":null}`, lines[1])
+	row := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &row))
+	assert.Equal(t, 1.5, row["price"])
+
+	assert.NotNil(t, ExportCSV(storage, "nosuch", out, CSVOptions{}))
+	assert.NotNil(t, ExportJSONLines(storage, "nosuch", out, JSONOptions{}))
+
+	rmSQLite(filename)
+}
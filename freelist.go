@@ -0,0 +1,88 @@
+package sqlite3utils
+
+/***********************************************************
+
+Freelist
+
+Trunk page:
+	0  page number of the next trunk page, 0 for the last one
+	4  number of leaf page numbers that follow
+	8  leaf page numbers, 4 bytes each
+
+Leaf pages hold no information. They still contain whatever was
+stored on them before they were freed.
+
+***********************************************************/
+
+// parseFreelist returns the trunk and leaf pages of the freelist.
+func parseFreelist(cnt []byte, header *Header) ([]int, []int, error) {
+	nPage := len(cnt) / header.pageSize
+	trunks := []int{}
+	leaves := []int{}
+
+	pageNum := header.freeTrunk1st
+	for pageNum != 0 {
+		if pageNum < 1 || pageNum > nPage || len(trunks) >= nPage {
+			return nil, nil, corruptf(pageNum, -1, -1, "freelist trunk page %d out of range", pageNum)
+		}
+		trunks = append(trunks, pageNum)
+
+		offset := header.pageSize * (pageNum - 1)
+		leafCount := fetchInt(cnt, offset+4, 4)
+		if leafCount > header.usableSize/4-2 {
+			return nil, nil, corruptf(pageNum, -1, offset+4, "freelist trunk page has %d leaves", leafCount)
+		}
+		for i := 0; i < leafCount; i++ {
+			leaf := fetchInt(cnt, offset+8+4*i, 4)
+			if leaf < 1 || leaf > nPage {
+				return nil, nil, corruptf(pageNum, -1, offset+8+4*i, "freelist leaf page %d out of range", leaf)
+			}
+			leaves = append(leaves, leaf)
+		}
+
+		pageNum = fetchInt(cnt, offset, 4)
+	}
+
+	return trunks, leaves, nil
+}
+
+// Freelist returns the trunk and leaf pages of the freelist.
+func (s *Storage) Freelist() ([]int, []int, error) {
+	return parseFreelist(s.cnt, s.Header)
+}
+
+// isPtrmapPage reports whether the page is a pointer-map page of an
+// auto-vacuum database.
+func isPtrmapPage(pageNum int, header *Header) bool {
+	// the largest root page is only set for auto-vacuum databases
+	if header.logest == 0 || pageNum < 2 {
+		return false
+	}
+	return (pageNum-2)%(header.usableSize/5+1) == 0
+}
+
+// isLockBytePage reports whether the page holds the lock bytes at
+// offset 1073741824, which SQLite never uses.
+func isLockBytePage(pageNum int, header *Header) bool {
+	return pageNum == 1073741824/header.pageSize+1
+}
+
+// unusedPages returns the pages that hold no b-tree and must not be
+// parsed as one.
+func unusedPages(cnt []byte, header *Header) (map[int]bool, error) {
+	trunks, leaves, err := parseFreelist(cnt, header)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[int]bool{}
+	for _, pageNum := range append(trunks, leaves...) {
+		ret[pageNum] = true
+	}
+	for pageNum := 1; header.pageSize*pageNum <= len(cnt); pageNum++ {
+		if isPtrmapPage(pageNum, header) || isLockBytePage(pageNum, header) {
+			ret[pageNum] = true
+		}
+	}
+	return ret, nil
+}
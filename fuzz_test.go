@@ -0,0 +1,158 @@
+package sqlite3utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// seedDatabases returns the database files under testdata.
+func seedDatabases(f *testing.F) [][]byte {
+	paths, _ := filepath.Glob("testdata/*.db")
+	seeds := [][]byte{}
+	for _, path := range paths {
+		cnt, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		seeds = append(seeds, cnt)
+	}
+	return seeds
+}
+
+// seedPages returns every page of the databases under testdata.
+func seedPages(f *testing.F) [][]byte {
+	pages := [][]byte{}
+	for _, cnt := range seedDatabases(f) {
+		header, err := parseHeader(cnt)
+		if err != nil {
+			continue
+		}
+		for offset := 0; offset+header.pageSize <= len(cnt); offset += header.pageSize {
+			pages = append(pages, cnt[offset:offset+header.pageSize])
+		}
+	}
+	return pages
+}
+
+func FuzzDecodeVarint(f *testing.F) {
+	f.Add([]byte{0})
+	f.Add([]byte{129, 0})
+	f.Add([]byte{255, 255, 255, 255, 255, 255, 255, 255, 255})
+
+	f.Fuzz(func(t *testing.T, bytes []byte) {
+		v, n := decodeVarint(bytes)
+		if n == 0 {
+			return
+		}
+		if n > uint(len(bytes)) {
+			t.Fatal("read past the end:", n, bytes)
+		}
+		w, m := decodeVarint(encodeVarint(v))
+		if v != w || m == 0 {
+			t.Fatal("round trip:", v, w)
+		}
+	})
+}
+
+func FuzzParseHeader(f *testing.F) {
+	for _, cnt := range seedDatabases(f) {
+		f.Add(cnt[:100])
+	}
+
+	f.Fuzz(func(t *testing.T, bytes []byte) {
+		parseHeader(bytes)
+	})
+}
+
+func FuzzParsePage(f *testing.F) {
+	for _, page := range seedPages(f) {
+		f.Add(page)
+	}
+
+	f.Fuzz(func(t *testing.T, page []byte) {
+		// page 2 of a database with 512-byte pages
+		cnt := make([]byte, 1024)
+		copy(cnt, "SQLite format 3\x00\x02\x00\x01\x01\x00\x40\x20\x20")
+		copy(cnt[512:], page)
+		header, err := parseHeader(cnt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsePage(cnt, 2, header, nil)
+		parsePage(cnt, 2, header, &diagnostics{})
+	})
+}
+
+func FuzzParseCell(f *testing.F) {
+	for _, page := range seedPages(f) {
+		f.Add(byte(leafTable), uint16(100), page)
+		f.Add(byte(interiorIndex), uint16(300), page)
+	}
+
+	f.Fuzz(func(t *testing.T, pageType byte, cellPtr uint16, page []byte) {
+		cnt := make([]byte, 1024)
+		copy(cnt, "SQLite format 3\x00\x02\x00\x01\x01\x00\x40\x20\x20")
+		copy(cnt[512:], page)
+		header, err := parseHeader(cnt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := &Page{pageNum: 2, pageType: int(pageType), cellPtrs: []int{int(cellPtr)}}
+		c, err := parseCell(cnt, p, header, 0)
+		if err != nil {
+			return
+		}
+		if c.offset+c.size > 1024 {
+			t.Fatal("cell runs past the page:", c.offset, c.size)
+		}
+		readPayload(cnt, header, c)
+	})
+}
+
+func FuzzDecodeRecord(f *testing.F) {
+	f.Add([]byte{2, 0})
+	f.Add([]byte{4, 1, 23, 7, 42, 'h', 'e', 'l', 'l', 'o', 0, 0, 0, 0, 0, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		decodeRecord(payload)
+	})
+}
+
+func FuzzParseWAL(f *testing.F) {
+	wal, err := ioutil.ReadFile("testdata/wal.db-wal")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(wal)
+
+	f.Fuzz(func(t *testing.T, bytes []byte) {
+		parseWAL(bytes).overlay(make([]byte, 512), 0)
+		w := parseWAL(bytes)
+		w.overlay(make([]byte, 512), w.mxFrame)
+	})
+}
+
+func FuzzLoad(f *testing.F) {
+	for _, cnt := range seedDatabases(f) {
+		f.Add(cnt)
+	}
+
+	f.Fuzz(func(t *testing.T, cnt []byte) {
+		filename := filepath.Join(t.TempDir(), "fuzz.db")
+		if err := ioutil.WriteFile(filename, cnt, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		storage, err := Load(filename)
+		if err == nil {
+			Check(storage)
+		}
+		storage, err = LoadWithOptions(filename, LoadOptions{Recover: true})
+		if err == nil {
+			Check(storage)
+		}
+	})
+}
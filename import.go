@@ -0,0 +1,480 @@
+package sqlite3utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// ImportOptions ...
+type ImportOptions struct {
+	Delimiter rune         // CSV, ',' if zero
+	Null      string       // CSV field read as NULL
+	Blob      BlobEncoding // encoding of the text given for BLOB columns
+
+	// BatchSize is the number of rows committed per transaction. All rows
+	// are inserted in one transaction if it is zero.
+	BatchSize int
+
+	Writer WriterOptions
+}
+
+// ImportResult ...
+type ImportResult struct {
+	Created  bool // the table did not exist
+	Inserted int
+	Rejected []*Rejection
+}
+
+// Rejection is an input line that was not imported.
+type Rejection struct {
+	Line   int
+	Reason string
+}
+
+func (r *Rejection) String() string {
+	return fmt.Sprintf("line %d: %s", r.Line, r.Reason)
+}
+
+// importRow is a parsed input line. Values are nil, int64, float64,
+// string or []byte.
+type importRow struct {
+	line   int
+	names  []string // keys of a JSON object, nil for CSV
+	values []interface{}
+	reason string // why the line could not be parsed
+}
+
+// ImportCSV inserts the rows of a CSV file, whose first line names the
+// columns, into a table of the database at path. A missing table is
+// created with INTEGER, REAL or TEXT columns depending on the values.
+//
+// This is a bulk append, not a general INSERT. Rows are appended to the
+// table b-tree after the largest rowid and no index is updated, so:
+//
+//   - a table with any index is refused with ErrUnsupported, including
+//     the automatic indexes of UNIQUE constraints and of a PRIMARY KEY
+//     that is not an INTEGER PRIMARY KEY;
+//   - WITHOUT ROWID and virtual tables are refused as well;
+//   - a row whose INTEGER PRIMARY KEY is not larger than every rowid
+//     before it is rejected.
+//
+// Lines that cannot be inserted are rejected and reported in the result.
+func ImportCSV(path, table string, in io.Reader, options ImportOptions) (*ImportResult, error) {
+	r := csv.NewReader(in)
+	if options.Delimiter != 0 {
+		r.Comma = options.Delimiter
+	}
+	r.FieldsPerRecord = -1
+
+	names, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("Missing header line")
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows := []*importRow{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if perr, ok := err.(*csv.ParseError); ok {
+			rows = append(rows, &importRow{line: perr.StartLine, reason: perr.Err.Error()})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		line, _ := r.FieldPos(0)
+		row := &importRow{line: line}
+		if len(record) != len(names) {
+			row.reason = fmt.Sprintf("expected %d fields, not %d", len(names), len(record))
+		}
+		for _, field := range record {
+			if field == options.Null {
+				row.values = append(row.values, nil)
+			} else {
+				row.values = append(row.values, field)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return importRows(path, table, names, rows, true, options)
+}
+
+// ImportJSONLines inserts JSON objects, one per line, into a table of the
+// database at path, mapping keys to columns. A missing table is created
+// with the keys in the order they first appear. Booleans become 0 and 1,
+// and arrays and objects are stored as JSON text. See ImportCSV for the
+// limits.
+func ImportJSONLines(path, table string, in io.Reader, options ImportOptions) (*ImportResult, error) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxPayload)
+
+	names := []string{}
+	seen := map[string]bool{}
+	rows := []*importRow{}
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		row := &importRow{line: line}
+		rows = append(rows, row)
+
+		keys, values, err := decodeJSONObject(text)
+		if err != nil {
+			row.reason = err.Error()
+			continue
+		}
+		row.names = keys
+		row.values = values
+		for _, key := range keys {
+			if !seen[strings.ToLower(key)] {
+				seen[strings.ToLower(key)] = true
+				names = append(names, key)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return importRows(path, table, names, rows, false, options)
+}
+
+// decodeJSONObject returns the keys of a JSON object in order and their
+// values.
+func decodeJSONObject(text []byte) ([]string, []interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(text))
+	decoder.UseNumber()
+	if t, err := decoder.Token(); err != nil {
+		return nil, nil, err
+	} else if t != json.Delim('{') {
+		return nil, nil, errors.New("not a JSON object")
+	}
+
+	keys := []string{}
+	values := []interface{}{}
+	for decoder.More() {
+		t, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, nil, err
+		}
+		var v interface{}
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		decoder.Decode(&v)
+
+		switch x := v.(type) {
+		case bool:
+			if x {
+				v = int64(1)
+			} else {
+				v = int64(0)
+			}
+		case json.Number:
+			if n, err := x.Int64(); err == nil {
+				v = n
+			} else {
+				f, _ := x.Float64()
+				v = f
+			}
+		case []interface{}, map[string]interface{}:
+			v = string(raw)
+		}
+		keys = append(keys, t.(string))
+		values = append(values, v)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, err
+	}
+	if decoder.More() {
+		return nil, nil, errors.New("data after the JSON object")
+	}
+	return keys, values, nil
+}
+
+// inferType returns the declared type for a new column holding values.
+// Text that looks like a number counts as one when numeric is set.
+func inferType(values []interface{}, numeric bool) string {
+	ret := ""
+	for _, v := range values {
+		if s, ok := v.(string); ok && numeric {
+			if n, ok := parseNumeric(s); ok {
+				v = n
+			}
+		}
+		switch v.(type) {
+		case nil:
+		case int64:
+			if ret == "" {
+				ret = "INTEGER"
+			}
+		case float64:
+			if ret != "TEXT" {
+				ret = "REAL"
+			}
+		default:
+			return "TEXT"
+		}
+	}
+	if ret == "" {
+		return "TEXT"
+	}
+	return ret
+}
+
+type importer struct {
+	w       *Writer
+	t       *treeWriter
+	options ImportOptions
+	result  *ImportResult
+
+	schema      *Schema
+	rowidColumn int
+	lastRowid   int64
+	pending     int
+}
+
+func importRows(path, table string, names []string, rows []*importRow, fromCSV bool, options ImportOptions) (*ImportResult, error) {
+	w, err := OpenWriter(path, options.Writer)
+	if err != nil {
+		return nil, err
+	}
+	im := &importer{w: w, t: newTreeWriter(w), options: options, result: &ImportResult{}}
+	if err := im.run(path, table, names, rows, fromCSV); err != nil {
+		w.Rollback()
+		return nil, err
+	}
+	return im.result, nil
+}
+
+func (im *importer) run(path, table string, names []string, rows []*importRow, fromCSV bool) error {
+	storage, err := parseStorage(path, im.w.cnt, nil, nil, 1, false)
+	if err != nil {
+		return err
+	}
+	if storage.Header.logest != 0 {
+		return unsupportedf("importing into an auto-vacuum database")
+	}
+
+	im.schema = storage.Schema(table)
+	if im.schema == nil {
+		if len(names) == 0 {
+			return errors.New("No columns to create the table from")
+		}
+		columns := make([][]interface{}, len(names))
+		for _, row := range rows {
+			for i, v := range row.values {
+				j := i
+				if row.names != nil {
+					j = indexFold(names, row.names[i])
+				}
+				if j < len(columns) {
+					columns[j] = append(columns[j], v)
+				}
+			}
+		}
+		defs := []string{}
+		for i, name := range names {
+			defs = append(defs, quoteIdent(name)+" "+inferType(columns[i], fromCSV))
+		}
+		sql := fmt.Sprintf("CREATE TABLE %s(%s)", quoteIdent(table), strings.Join(defs, ", "))
+		if im.schema, err = im.createTable(table, sql); err != nil {
+			return err
+		}
+		im.result.Created = true
+	} else if err := checkImportTable(storage, im.schema); err != nil {
+		return err
+	}
+
+	im.rowidColumn = im.schema.RowidColumn()
+	if im.lastRowid, err = im.t.maxRowid(im.schema.RootPage); err != nil {
+		return err
+	}
+
+	// CSV columns are positional, so all names must be known
+	order := []int{}
+	for _, name := range names {
+		i := im.schema.Column(name)
+		if i < 0 && fromCSV {
+			return fmt.Errorf("No column %s in table %s", name, table)
+		}
+		order = append(order, i)
+	}
+
+	for _, row := range rows {
+		if row.reason != "" {
+			im.reject(row, row.reason)
+			continue
+		}
+		if err := im.insert(row, order); err != nil {
+			return err
+		}
+	}
+	return im.w.Commit()
+}
+
+// checkImportTable returns an error if rows cannot be appended to the
+// table.
+func checkImportTable(storage *Storage, schema *Schema) error {
+	if schema.Type != "table" {
+		return fmt.Errorf("%s is not a table", schema.Name)
+	}
+	if strings.HasPrefix(strings.ToUpper(schema.SQL), "CREATE VIRTUAL TABLE") || schema.RootPage == 0 {
+		return unsupportedf("importing into virtual table %s", schema.Name)
+	}
+	if schema.WithoutRowid {
+		return unsupportedf("importing into WITHOUT ROWID table %s", schema.Name)
+	}
+	if len(schema.Columns) == 0 {
+		return fmt.Errorf("No columns known for table %s", schema.Name)
+	}
+	for _, s := range storage.Schemas {
+		if s.Type == "index" && strings.EqualFold(s.TableName, schema.Name) {
+			return unsupportedf("importing into table %s, which has index %s", schema.Name, s.Name)
+		}
+	}
+	return nil
+}
+
+// createTable adds an empty table to sqlite_master.
+func (im *importer) createTable(name, sql string) (*Schema, error) {
+	root, err := im.t.createTable()
+	if err != nil {
+		return nil, err
+	}
+	rowid, err := im.t.maxRowid(1)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := im.w.header.encoding
+	record := encodeRecord([]*Data{
+		textData("table", encoding),
+		textData(name, encoding),
+		textData(name, encoding),
+		smallIntData(int64(root), im.w.header.schemaNumber),
+		textData(sql, encoding),
+	})
+	if err := im.t.appendRow(1, rowid+1, record); err != nil {
+		return nil, err
+	}
+
+	// the schema cookie tells connections to reload the schema
+	page1, err := im.w.Page(1)
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(page1[40:], binary.BigEndian.Uint32(page1[40:])+1)
+	if err := im.w.WritePage(1, page1); err != nil {
+		return nil, err
+	}
+
+	schema := &Schema{Type: "table", Name: name, TableName: name, RootPage: root, SQL: sql}
+	parseSchema(schema)
+	return schema, nil
+}
+
+func (im *importer) reject(row *importRow, reason string) {
+	im.result.Rejected = append(im.result.Rejected, &Rejection{row.line, reason})
+}
+
+// insert appends a row, or rejects it when a value does not fit.
+func (im *importer) insert(row *importRow, order []int) error {
+	values := make([]interface{}, len(im.schema.Columns))
+	for i, v := range row.values {
+		n := -1
+		if row.names != nil {
+			n = im.schema.Column(row.names[i])
+			if n < 0 {
+				im.reject(row, fmt.Sprintf("no column named %s", row.names[i]))
+				return nil
+			}
+		} else if i < len(order) {
+			n = order[i]
+		}
+		if n >= 0 {
+			values[n] = v
+		}
+	}
+
+	rowid, auto := im.lastRowid+1, true
+	datas := []*Data{}
+	for i, column := range im.schema.Columns {
+		v := values[i]
+		if s, ok := v.(string); ok && strings.Contains(strings.ToUpper(column.Type), "BLOB") {
+			bs, err := im.options.Blob.decode(s)
+			if err != nil {
+				im.reject(row, fmt.Sprintf("column %s: %v", column.Name, err))
+				return nil
+			}
+			v = bs
+		}
+		v = applyAffinity(v, column.Affinity())
+
+		if i == im.rowidColumn {
+			if v != nil {
+				n, ok := v.(int64)
+				if !ok {
+					im.reject(row, "datatype mismatch")
+					return nil
+				}
+				rowid, auto = n, false
+			}
+			// the record holds NULL for the rowid alias
+			v = nil
+		} else if v == nil && column.NotNull {
+			im.reject(row, fmt.Sprintf("NOT NULL constraint failed: %s.%s", im.schema.Name, column.Name))
+			return nil
+		}
+
+		datas = append(datas, valueData(v, im.w.header))
+	}
+
+	if auto && im.lastRowid == math.MaxInt64 {
+		im.reject(row, "no rowid left after the largest one")
+		return nil
+	}
+	if rowid <= im.lastRowid {
+		im.reject(row, fmt.Sprintf("rowid %d is not after the last rowid %d", rowid, im.lastRowid))
+		return nil
+	}
+
+	if err := im.t.appendRow(im.schema.RootPage, rowid, encodeRecord(datas)); err != nil {
+		return err
+	}
+	im.lastRowid = rowid
+	im.result.Inserted++
+
+	im.pending++
+	if im.options.BatchSize > 0 && im.pending >= im.options.BatchSize {
+		im.pending = 0
+		return im.w.Commit()
+	}
+	return nil
+}
+
+// indexFold returns the position of name in names ignoring case, or
+// len(names).
+func indexFold(names []string, name string) int {
+	for i, n := range names {
+		if strings.EqualFold(n, name) {
+			return i
+		}
+	}
+	return len(names)
+}
@@ -0,0 +1,138 @@
+package sqlite3utils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// integrityCheck returns the result of PRAGMA integrity_check of sqlite3.
+func integrityCheck(t *testing.T, filename string) string {
+	execSQLite(filename, []string{
+		"CREATE TABLE verified(result);",
+		"INSERT INTO verified SELECT group_concat(integrity_check) FROM pragma_integrity_check;",
+	})
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	rows := storage.Tables["verified"].Entries
+	execSQLite(filename, []string{"DROP TABLE verified;"})
+	return rows[0].Datas[0].Value
+}
+
+func TestImportCSV(t *testing.T) {
+	filename := "/tmp/test_import.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"PRAGMA page_size = 512; CREATE TABLE person(id integer primary key, name text not null, score real, data blob);",
+		"INSERT INTO person VALUES (1, \"first\", 1, NULL);",
+	})
+
+	in := &bytes.Buffer{}
+	in.WriteString("name,id,score,data\n")
+	for i := 2; i <= 2000; i++ {
+		fmt.Fprintf(in, "person-%d,%d,%d.5,\n", i, i, i)
+	}
+	in.WriteString("long," + "," + strings.Repeat("x", 2000) + ",6869\n")
+	in.WriteString("early,1,,\n")
+	in.WriteString(",,,\n")
+	in.WriteString("short,\n")
+	in.WriteString("badblob,,,zz\n")
+
+	result, err := ImportCSV(filename, "person", in, ImportOptions{BatchSize: 500})
+	assert.Nil(t, err)
+	assert.False(t, result.Created)
+	assert.Equal(t, 2000, result.Inserted)
+	assert.Equal(t, 4, len(result.Rejected))
+	assert.Equal(t, 2002, result.Rejected[0].Line)
+	assert.Equal(t, "rowid 1 is not after the last rowid 2001", result.Rejected[0].Reason)
+	assert.Equal(t, "NOT NULL constraint failed: person.name", result.Rejected[1].Reason)
+	assert.Equal(t, "expected 4 fields, not 2", result.Rejected[2].Reason)
+	assert.Equal(t, 2005, result.Rejected[3].Line)
+
+	assert.Equal(t, "ok", integrityCheck(t, filename))
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	assert.Empty(t, Check(storage))
+	entries := storage.Tables["person"].Entries
+	assert.Equal(t, 2001, len(entries))
+	assert.Equal(t, uint64(1000), entries[999].Rowid)
+	assert.Equal(t, "person-1000", entries[999].Datas[1].Value)
+	assert.Equal(t, 1000.5, entries[999].Datas[2].float64())
+	last := entries[2000]
+	assert.Equal(t, uint64(2001), last.Rowid)
+	assert.Equal(t, 2000, len(last.Datas[2].Value))
+	assert.Equal(t, []byte("hi"), last.Datas[3].Bytes)
+
+	// the index would have to be updated as well
+	execSQLite(filename, []string{"CREATE INDEX person_name ON person(name);"})
+	_, err = ImportCSV(filename, "person", strings.NewReader("name\nx\n"), ImportOptions{})
+	assert.ErrorIs(t, err, ErrUnsupported)
+
+	// so would the automatic index of a UNIQUE constraint
+	execSQLite(filename, []string{"CREATE TABLE code(id INTEGER PRIMARY KEY, code TEXT UNIQUE);"})
+	_, err = ImportCSV(filename, "code", strings.NewReader("code\nx\n"), ImportOptions{})
+	assert.ErrorIs(t, err, ErrUnsupported)
+	assert.Contains(t, err.Error(), "sqlite_autoindex_code_1")
+
+	rmSQLite(filename)
+}
+
+func TestImportCreate(t *testing.T) {
+	filename := "/tmp/test_import_create.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{"PRAGMA page_size = 512; CREATE TABLE other(x);"})
+
+	in := "id,name,price\n1,apple,1.5\n2,banana,2\n3,,\n"
+	result, err := ImportCSV(filename, "fruit", strings.NewReader(in), ImportOptions{})
+	assert.Nil(t, err)
+	assert.True(t, result.Created)
+	assert.Equal(t, 3, result.Inserted)
+
+	in = `{"name": "cherry", "tags": ["red"], "fresh": true, "price": 3}` + "\n" +
+		"not json\n" +
+		`{"name": "date", "price": 4.25, "color": "brown"}` + "\n"
+	result, err = ImportJSONLines(filename, "fruit", strings.NewReader(in), ImportOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.Inserted)
+	assert.Equal(t, 3, len(result.Rejected))
+	assert.Equal(t, "no column named tags", result.Rejected[0].Reason)
+
+	result, err = ImportJSONLines(filename, "basket", strings.NewReader(in), ImportOptions{})
+	assert.Nil(t, err)
+	assert.True(t, result.Created)
+	assert.Equal(t, 2, result.Inserted)
+	assert.Equal(t, 2, result.Rejected[0].Line)
+
+	// many tables split the root of sqlite_master on page 1
+	for i := 0; i < 20; i++ {
+		_, err := ImportCSV(filename, fmt.Sprintf("table_with_a_long_name_%d", i), strings.NewReader("a\n1\n"), ImportOptions{})
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, "ok", integrityCheck(t, filename))
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	assert.Empty(t, Check(storage))
+	assert.Equal(t, "CREATE TABLE fruit(id INTEGER, name TEXT, price REAL)", storage.Schema("fruit").SQL)
+	assert.Equal(t, "CREATE TABLE basket(name TEXT, tags TEXT, fresh INTEGER, price REAL, color TEXT)", storage.Schema("basket").SQL)
+	assert.Equal(t, 23, len(storage.Schemas))
+	assert.Equal(t, 1, len(storage.Tables["table_with_a_long_name_19"].Entries))
+
+	fruit := storage.Tables["fruit"].Entries
+	assert.Equal(t, "banana", fruit[1].Datas[1].Value)
+	assert.Equal(t, 2.0, fruit[1].Datas[2].float64())
+	assert.True(t, fruit[2].Datas[1].isNull())
+
+	basket := storage.Tables["basket"].Entries
+	assert.Equal(t, `["red"]`, basket[0].Datas[1].Value)
+	assert.Equal(t, "1", basket[0].Datas[2].Value)
+	assert.Equal(t, 4.25, basket[1].Datas[3].float64())
+	assert.Equal(t, "brown", basket[1].Datas[4].Value)
+
+	rmSQLite(filename)
+}
@@ -0,0 +1,420 @@
+package sqlite3utils
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"unicode/utf16"
+)
+
+// Writing b-trees is limited to appending rows to rowid tables: every new
+// row has a larger rowid than the rows already stored, so it always goes
+// to the rightmost leaf, and a full page is split by starting a new
+// rightmost sibling, like balance_quick does in SQLite.
+
+// realData returns a REAL field.
+func realData(f float64) *Data {
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, math.Float64bits(f))
+	d, _ := takeData(bs, 7)
+	return d
+}
+
+// smallIntData returns an integer field of the smallest serial type that
+// holds v. Schema format 4 is needed for the serial types of 0 and 1.
+func smallIntData(v int64, schemaFormat int) *Data {
+	if schemaFormat >= 4 && (v == 0 || v == 1) {
+		d, _ := takeData(nil, 8+int(v))
+		return d
+	}
+	serialType, size := 6, 8
+	switch {
+	case v >= -1<<7 && v < 1<<7:
+		serialType, size = 1, 1
+	case v >= -1<<15 && v < 1<<15:
+		serialType, size = 2, 2
+	case v >= -1<<23 && v < 1<<23:
+		serialType, size = 3, 3
+	case v >= -1<<31 && v < 1<<31:
+		serialType, size = 4, 4
+	case v >= -1<<47 && v < 1<<47:
+		serialType, size = 5, 6
+	}
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, uint64(v))
+	d, _ := takeData(bs[8-size:], serialType)
+	d.Value = strconv.FormatInt(v, 10)
+	return d
+}
+
+// textData returns a TEXT field in the text encoding of the database.
+func textData(s string, encoding int) *Data {
+	bs := []byte(s)
+	if encoding == 2 || encoding == 3 {
+		var order binary.ByteOrder = binary.LittleEndian
+		if encoding == 3 {
+			order = binary.BigEndian
+		}
+		units := utf16.Encode([]rune(s))
+		bs = make([]byte, 2*len(units))
+		for i, u := range units {
+			order.PutUint16(bs[2*i:], u)
+		}
+	}
+	d, _ := takeData(bs, 13+2*len(bs))
+	d.Value = s
+	return d
+}
+
+// blobData returns a BLOB field.
+func blobData(bs []byte) *Data {
+	d, _ := takeData(bs, 12+2*len(bs))
+	return d
+}
+
+// encodeRecord is the reverse of decodeRecord.
+func encodeRecord(datas []*Data) []byte {
+	types := []byte{}
+	body := []byte{}
+	for _, d := range datas {
+		types = append(types, encodeVarint(uint64(d.SerialType))...)
+		body = append(body, d.Bytes...)
+	}
+	// the header size counts its own varint
+	headerSize := len(types) + 1
+	for len(encodeVarint(uint64(headerSize)))+len(types) != headerSize {
+		headerSize++
+	}
+	ret := encodeVarint(uint64(headerSize))
+	ret = append(ret, types...)
+	return append(ret, body...)
+}
+
+// treePage is a b-tree page taken apart into its cells so that it can be
+// rebuilt without fragmentation after cells are added or removed.
+type treePage struct {
+	num       int
+	pageType  int
+	image     []byte // image the page was loaded from
+	hdrOffset int    // 100 on page 1
+	cells     [][]byte
+	rightPtr  int
+}
+
+func (p *treePage) headerSize() int {
+	if p.pageType == interiorTable || p.pageType == interiorIndex {
+		return 12
+	}
+	return 8
+}
+
+// fits reports whether the page has room for one more cell.
+func (p *treePage) fits(header *Header, c []byte) bool {
+	used := p.hdrOffset + p.headerSize() + 2*(len(p.cells)+1) + len(c)
+	for _, c := range p.cells {
+		used += len(c)
+	}
+	return used <= header.usableSize
+}
+
+// treeWriter appends rows to table b-trees through a Writer.
+type treeWriter struct {
+	w      *Writer
+	header *Header
+}
+
+func newTreeWriter(w *Writer) *treeWriter {
+	return &treeWriter{w: w, header: w.header}
+}
+
+func (t *treeWriter) load(num int) (*treePage, error) {
+	image, err := t.w.Page(num)
+	if err != nil {
+		return nil, err
+	}
+	p := &treePage{num: num, image: image}
+	if num == 1 {
+		p.hdrOffset = 100
+	}
+	p.pageType = int(image[p.hdrOffset])
+	if p.pageType != interiorTable && p.pageType != leafTable {
+		return nil, corruptf(num, -1, -1, "page type %d is not a table b-tree page", p.pageType)
+	}
+	if p.pageType == interiorTable {
+		p.rightPtr = fetchInt(image, p.hdrOffset+8, 4)
+	}
+
+	count := fetchInt(image, p.hdrOffset+3, 2)
+	ptrs := p.hdrOffset + p.headerSize()
+	if ptrs+2*count > t.header.usableSize {
+		return nil, corruptf(num, -1, -1, "%d cells do not fit the page", count)
+	}
+	// the image is parsed as if it were the first page of a file
+	page := &Page{pageNum: 1, pageType: p.pageType}
+	for i := 0; i < count; i++ {
+		page.cellPtrs = append(page.cellPtrs, fetchInt(image, ptrs+2*i, 2))
+	}
+	for i := range page.cellPtrs {
+		c, err := parseCell(image, page, t.header, i)
+		if err != nil {
+			return nil, corruptf(num, i, -1, "%s", err.(*CorruptError).Reason)
+		}
+		p.cells = append(p.cells, fetchCopy(image, c.offset, c.size))
+	}
+	return p, nil
+}
+
+// store writes the page with its cells packed at the end.
+func (t *treeWriter) store(p *treePage) error {
+	image := make([]byte, t.header.pageSize)
+	if p.image != nil {
+		// database header and reserved space
+		copy(image[:p.hdrOffset], p.image)
+		copy(image[t.header.usableSize:], p.image[t.header.usableSize:])
+	}
+
+	h := p.hdrOffset
+	image[h] = byte(p.pageType)
+	binary.BigEndian.PutUint16(image[h+3:], uint16(len(p.cells)))
+	if p.pageType == interiorTable {
+		binary.BigEndian.PutUint32(image[h+8:], uint32(p.rightPtr))
+	}
+
+	content := t.header.usableSize
+	ptrs := h + p.headerSize()
+	for i, c := range p.cells {
+		content -= len(c)
+		copy(image[content:], c)
+		binary.BigEndian.PutUint16(image[ptrs+2*i:], uint16(content))
+	}
+	// 65536 is written as 0
+	binary.BigEndian.PutUint16(image[h+5:], uint16(content))
+	return t.w.WritePage(p.num, image)
+}
+
+// allocate adds a page to the end of the database, skipping the lock-byte
+// page.
+func (t *treeWriter) allocate() (int, error) {
+	num := t.w.DbSize() + 1
+	if isLockBytePage(num, t.header) {
+		if err := t.w.WritePage(num, make([]byte, t.header.pageSize)); err != nil {
+			return 0, err
+		}
+		num++
+	}
+	return num, t.w.WritePage(num, make([]byte, t.header.pageSize))
+}
+
+// createTable allocates the root page of an empty table.
+func (t *treeWriter) createTable() (int, error) {
+	num, err := t.allocate()
+	if err != nil {
+		return 0, err
+	}
+	return num, t.store(&treePage{num: num, pageType: leafTable})
+}
+
+// rightmostPath returns the pages from the root down to the rightmost
+// leaf.
+func (t *treeWriter) rightmostPath(root int) ([]*treePage, error) {
+	path := []*treePage{}
+	visited := map[int]bool{}
+	num := root
+	for {
+		if visited[num] {
+			return nil, corruptf(num, -1, -1, "b-tree loop")
+		}
+		visited[num] = true
+
+		p, err := t.load(num)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, p)
+		if p.pageType == leafTable {
+			return path, nil
+		}
+		num = p.rightPtr
+	}
+}
+
+// maxRowid returns the largest rowid in the table, 0 if it is empty.
+func (t *treeWriter) maxRowid(root int) (int64, error) {
+	path, err := t.rightmostPath(root)
+	if err != nil {
+		return 0, err
+	}
+	leaf := path[len(path)-1]
+	if len(leaf.cells) == 0 {
+		return 0, nil
+	}
+	return cellKey(leaf.pageType, leaf.cells[len(leaf.cells)-1]), nil
+}
+
+// cellKey returns the rowid of a table b-tree cell.
+func cellKey(pageType int, c []byte) int64 {
+	if pageType == interiorTable {
+		v, _, _ := varintAt(c, 4)
+		return int64(v)
+	}
+	_, n, _ := varintAt(c, 0)
+	v, _, _ := varintAt(c, n)
+	return int64(v)
+}
+
+func interiorCell(child int, key int64) []byte {
+	ret := make([]byte, 4)
+	binary.BigEndian.PutUint32(ret, uint32(child))
+	return append(ret, encodeVarint(uint64(key))...)
+}
+
+// leafCell builds a table leaf cell, spilling the end of the payload to
+// overflow pages.
+func (t *treeWriter) leafCell(rowid int64, payload []byte) ([]byte, error) {
+	ret := encodeVarint(uint64(len(payload)))
+	ret = append(ret, encodeVarint(uint64(rowid))...)
+	local := localPayload(t.header, leafTable, len(payload))
+	ret = append(ret, payload[:local]...)
+
+	if local < len(payload) {
+		first, err := t.writeOverflow(payload[local:])
+		if err != nil {
+			return nil, err
+		}
+		ptr := make([]byte, 4)
+		binary.BigEndian.PutUint32(ptr, uint32(first))
+		ret = append(ret, ptr...)
+	}
+	for len(ret) < 4 {
+		ret = append(ret, 0)
+	}
+	return ret, nil
+}
+
+// writeOverflow stores data in a chain of overflow pages and returns the
+// first one.
+func (t *treeWriter) writeOverflow(data []byte) (int, error) {
+	size := t.header.usableSize - 4
+	nums := []int{}
+	for i := 0; i < len(data); i += size {
+		num, err := t.allocate()
+		if err != nil {
+			return 0, err
+		}
+		nums = append(nums, num)
+	}
+	for i, num := range nums {
+		image := make([]byte, t.header.pageSize)
+		if i+1 < len(nums) {
+			binary.BigEndian.PutUint32(image, uint32(nums[i+1]))
+		}
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		copy(image[4:], data[i*size:end])
+		if err := t.w.WritePage(num, image); err != nil {
+			return 0, err
+		}
+	}
+	return nums[0], nil
+}
+
+// pushDown moves the content of the root to a new child, leaving the
+// root an interior page without cells that points to it.
+func (t *treeWriter) pushDown(root *treePage) (*treePage, error) {
+	num, err := t.allocate()
+	if err != nil {
+		return nil, err
+	}
+	child := &treePage{num: num, pageType: root.pageType, cells: root.cells, rightPtr: root.rightPtr}
+	if err := t.store(child); err != nil {
+		return nil, err
+	}
+	root.pageType = interiorTable
+	root.cells = nil
+	root.rightPtr = num
+	return child, t.store(root)
+}
+
+// appendRow adds a row whose rowid is larger than every rowid in the
+// table.
+func (t *treeWriter) appendRow(root int, rowid int64, record []byte) error {
+	c, err := t.leafCell(rowid, record)
+	if err != nil {
+		return err
+	}
+	path, err := t.rightmostPath(root)
+	if err != nil {
+		return err
+	}
+
+	leaf := path[len(path)-1]
+	if leaf.fits(t.header, c) {
+		leaf.cells = append(leaf.cells, c)
+		return t.store(leaf)
+	}
+
+	if len(path) == 1 {
+		child, err := t.pushDown(leaf)
+		if err != nil {
+			return err
+		}
+		path = append(path, child)
+		leaf = child
+		if leaf.fits(t.header, c) {
+			// only page 1 lacks room for a single cell
+			leaf.cells = append(leaf.cells, c)
+			return t.store(leaf)
+		}
+	}
+
+	num, err := t.allocate()
+	if err != nil {
+		return err
+	}
+	if err := t.store(&treePage{num: num, pageType: leafTable, cells: [][]byte{c}}); err != nil {
+		return err
+	}
+
+	// the old rightmost child gets a divider cell in its parent, and the
+	// new page becomes the rightmost child, splitting the parent the same
+	// way when it is full
+	child, key, right := leaf.num, cellKey(leafTable, leaf.cells[len(leaf.cells)-1]), num
+	for i := len(path) - 2; i >= 0; i-- {
+		parent := path[i]
+		divider := interiorCell(child, key)
+		if parent.fits(t.header, divider) {
+			parent.cells = append(parent.cells, divider)
+			parent.rightPtr = right
+			return t.store(parent)
+		}
+
+		if i == 0 {
+			pushed, err := t.pushDown(parent)
+			if err != nil {
+				return err
+			}
+			path = append([]*treePage{parent, pushed}, path[1:]...)
+			i = 2
+			continue
+		}
+
+		last := parent.cells[len(parent.cells)-1]
+		parent.cells = parent.cells[:len(parent.cells)-1]
+		parent.rightPtr = int(binary.BigEndian.Uint32(last))
+		if err := t.store(parent); err != nil {
+			return err
+		}
+
+		sibling, err := t.allocate()
+		if err != nil {
+			return err
+		}
+		if err := t.store(&treePage{num: sibling, pageType: interiorTable, cells: [][]byte{divider}, rightPtr: right}); err != nil {
+			return err
+		}
+		child, key, right = parent.num, cellKey(interiorTable, last), sibling
+	}
+	return nil
+}
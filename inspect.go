@@ -0,0 +1,235 @@
+package sqlite3utils
+
+import (
+	"fmt"
+)
+
+// Page types reported by InspectPage.
+const (
+	PageInteriorIndex = "interior index"
+	PageInteriorTable = "interior table"
+	PageLeafIndex     = "leaf index"
+	PageLeafTable     = "leaf table"
+	PageOverflow      = "overflow"
+	PageFreelistTrunk = "freelist trunk"
+	PageFreelistLeaf  = "freelist leaf"
+	PagePtrmap        = "ptrmap"
+	PageLockByte      = "lock-byte"
+	PageUnused        = "unused"
+)
+
+// PageInfo describes the layout of a page. The fields after Owner are
+// only set for b-tree pages. Offsets are relative to the start of the
+// page.
+type PageInfo struct {
+	Number int
+	Type   string
+	// Owner is the table or index using the page, or "freelist" or
+	// "ptrmap". It is empty for pages nothing refers to.
+	Owner string
+
+	CellCount        int
+	CellContentStart int // start of the cell content area
+	FirstFreeblock   int // 0 if there is no freeblock
+	Freeblocks       []*FreeblockInfo
+	FragmentedBytes  int
+	RightPointer     int // right-most child of an interior page
+	Cells            []*CellInfo
+}
+
+// FreeblockInfo is a freeblock in the content area of a b-tree page.
+type FreeblockInfo struct {
+	Offset int
+	Size   int
+}
+
+// CellInfo is a cell of a b-tree page.
+type CellInfo struct {
+	Offset       int
+	Size         int    // bytes on the page, including the overflow pointer
+	LeftChild    int    // child page of an interior cell
+	Rowid        uint64 // key of a table cell
+	PayloadSize  int    // total payload, 0 for interior table cells
+	LocalPayload int    // payload bytes stored on the page
+	OverflowPage int    // first overflow page, 0 if none
+
+	// Err is set instead of the fields above if the cell is corrupt.
+	Err error
+}
+
+// pageOwners returns the object using each page, by page number - 1.
+// It is computed on first use, by following the freelist and the child
+// and overflow pointers of every b-tree without decoding any record.
+func (s *Storage) pageOwners() []string {
+	s.ownersOnce.Do(func() {
+		s.owners = make([]string, len(s.Pages))
+		s.ownFreelist()
+		for pageNum := 2; pageNum <= len(s.owners); pageNum++ {
+			if isPtrmapPage(pageNum, s.Header) {
+				s.own(pageNum, "ptrmap")
+			}
+		}
+		s.ownTree(1, "sqlite_master", true)
+		for _, schema := range s.Schemas {
+			if schema.RootPage > 0 {
+				s.ownTree(schema.RootPage, schema.Name, schema.Type == "table" && !schema.WithoutRowid)
+			}
+		}
+	})
+	return s.owners
+}
+
+// own records that owner uses the page, unless the page is out of range,
+// the lock-byte page or already used.
+func (s *Storage) own(pageNum int, owner string) bool {
+	if pageNum < 1 || pageNum > len(s.owners) || isLockBytePage(pageNum, s.Header) || s.owners[pageNum-1] != "" {
+		return false
+	}
+	s.owners[pageNum-1] = owner
+	return true
+}
+
+func (s *Storage) ownFreelist() {
+	for pageNum := s.Header.freeTrunk1st; s.own(pageNum, "freelist"); {
+		offset := s.Header.pageSize * (pageNum - 1)
+		leafCount := fetchInt(s.cnt, offset+4, 4)
+		if leafCount > s.Header.usableSize/4-2 {
+			return
+		}
+		for i := 0; i < leafCount; i++ {
+			s.own(fetchInt(s.cnt, offset+8+4*i, 4), "freelist")
+		}
+		pageNum = fetchInt(s.cnt, offset, 4)
+	}
+}
+
+// ownTree marks the pages of a b-tree and of the overflow chains of its
+// cells.
+func (s *Storage) ownTree(pageNum int, owner string, isTable bool) {
+	if !s.own(pageNum, owner) {
+		return
+	}
+	page := s.Pages[pageNum-1]
+	interior, leaf := interiorIndex, leafIndex
+	if isTable {
+		interior, leaf = interiorTable, leafTable
+	}
+	if page.pageType != interior && page.pageType != leaf {
+		return
+	}
+
+	for i := range page.cellPtrs {
+		c, err := parseCell(s.cnt, page, s.Header, i)
+		if err != nil {
+			continue
+		}
+		next := c.overflow
+		for n := c.overflowPages(s.Header); n > 0 && s.own(next, owner); n-- {
+			next = fetchInt(s.cnt, s.Header.pageSize*(next-1), 4)
+		}
+		if page.pageType == interior {
+			s.ownTree(c.child, owner, isTable)
+		}
+	}
+	if page.pageType == interior {
+		s.ownTree(page.rightPtr, owner, isTable)
+	}
+}
+
+// InspectPages returns the layout of every page.
+func (s *Storage) InspectPages() []*PageInfo {
+	ret := []*PageInfo{}
+	for pageNum := 1; pageNum <= len(s.Pages); pageNum++ {
+		info, _ := s.InspectPage(pageNum)
+		ret = append(ret, info)
+	}
+	return ret
+}
+
+// InspectPage returns the layout of a page.
+func (s *Storage) InspectPage(pageNum int) (*PageInfo, error) {
+	if pageNum < 1 || pageNum > len(s.Pages) {
+		return nil, fmt.Errorf("Page %d out of range", pageNum)
+	}
+	page := s.Pages[pageNum-1]
+	info := &PageInfo{
+		Number:     pageNum,
+		Owner:      s.pageOwners()[pageNum-1],
+		Freeblocks: []*FreeblockInfo{},
+		Cells:      []*CellInfo{},
+	}
+
+	switch page.pageType {
+	case interiorIndex:
+		info.Type = PageInteriorIndex
+	case interiorTable:
+		info.Type = PageInteriorTable
+	case leafIndex:
+		info.Type = PageLeafIndex
+	case leafTable:
+		info.Type = PageLeafTable
+	default:
+		info.Type = s.otherPageType(pageNum, info.Owner)
+		return info, nil
+	}
+	if info.Owner == "freelist" || info.Owner == "ptrmap" {
+		// a b-tree page header left on a page used for something else
+		info.Type = s.otherPageType(pageNum, info.Owner)
+		return info, nil
+	}
+
+	info.CellCount = page.cellCount
+	info.CellContentStart = page.startCellPtr
+	info.FirstFreeblock = page.freeBlock
+	info.FragmentedBytes = page.fragments
+	info.RightPointer = page.rightPtr
+
+	for i := range page.cellPtrs {
+		c, err := parseCell(s.cnt, page, s.Header, i)
+		if err != nil {
+			info.Cells = append(info.Cells, &CellInfo{Offset: page.cellPtrs[i], Err: err})
+			continue
+		}
+		info.Cells = append(info.Cells, &CellInfo{
+			Offset:       page.cellPtrs[i],
+			Size:         c.size,
+			LeftChild:    c.child,
+			Rowid:        c.rowid,
+			PayloadSize:  c.payloadSize,
+			LocalPayload: c.nLocal,
+			OverflowPage: c.overflow,
+		})
+	}
+
+	pageOffset := s.Header.pageSize * (pageNum - 1)
+	prev := 0
+	for block := page.freeBlock; block > prev && block+4 <= s.Header.usableSize; {
+		size := fetchInt(s.cnt, pageOffset+block+2, 2)
+		info.Freeblocks = append(info.Freeblocks, &FreeblockInfo{Offset: block, Size: size})
+		prev = block
+		block = fetchInt(s.cnt, pageOffset+block, 2)
+	}
+
+	return info, nil
+}
+
+// otherPageType tells what a page without a b-tree header is used for.
+func (s *Storage) otherPageType(pageNum int, owner string) string {
+	switch {
+	case isLockBytePage(pageNum, s.Header):
+		return PageLockByte
+	case owner == "ptrmap":
+		return PagePtrmap
+	case owner == "freelist":
+		trunks, _, _ := parseFreelist(s.cnt, s.Header)
+		for _, trunk := range trunks {
+			if trunk == pageNum {
+				return PageFreelistTrunk
+			}
+		}
+		return PageFreelistLeaf
+	case owner != "":
+		return PageOverflow
+	}
+	return PageUnused
+}
@@ -0,0 +1,70 @@
+package sqlite3utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspectPage(t *testing.T) {
+	filename := "/tmp/test_inspect.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"PRAGMA page_size = 512; CREATE TABLE person(id integer, name text);",
+		"CREATE TABLE memo(body text); CREATE INDEX person_name ON person(name);",
+		"INSERT INTO person VALUES (1, \"hoge\"), (2, \"foo\"), (3, \"bar\");",
+		"INSERT INTO memo VALUES (printf(\"%.1000c\", \"x\")); DELETE FROM person WHERE id = 2;",
+		"INSERT INTO memo VALUES (printf(\"%.1000c\", \"y\")); DELETE FROM memo WHERE body LIKE \"y%\";",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+
+	_, err = storage.InspectPage(0)
+	assert.NotNil(t, err)
+
+	info, err := storage.InspectPage(1)
+	assert.Nil(t, err)
+	assert.Equal(t, PageLeafTable, info.Type)
+	assert.Equal(t, "sqlite_master", info.Owner)
+	assert.Equal(t, 3, info.CellCount)
+
+	info, _ = storage.InspectPage(storage.Schema("person").RootPage)
+	assert.Equal(t, PageLeafTable, info.Type)
+	assert.Equal(t, "person", info.Owner)
+	assert.Equal(t, 2, len(info.Cells))
+	assert.Equal(t, uint64(3), info.Cells[1].Rowid)
+	assert.Equal(t, 1, len(info.Freeblocks))
+	assert.Equal(t, info.FirstFreeblock, info.Freeblocks[0].Offset)
+
+	info, _ = storage.InspectPage(storage.Schema("person_name").RootPage)
+	assert.Equal(t, PageLeafIndex, info.Type)
+	assert.Equal(t, "person_name", info.Owner)
+
+	info, _ = storage.InspectPage(storage.Schema("memo").RootPage)
+	assert.Equal(t, 1, len(info.Cells))
+	cell := info.Cells[0]
+	assert.Nil(t, cell.Err)
+	assert.Equal(t, 1003, cell.PayloadSize)
+	assert.True(t, cell.LocalPayload < cell.PayloadSize)
+	assert.NotEqual(t, 0, cell.OverflowPage)
+
+	info, _ = storage.InspectPage(cell.OverflowPage)
+	assert.Equal(t, PageOverflow, info.Type)
+	assert.Equal(t, "memo", info.Owner)
+
+	types := map[string]int{}
+	for _, info := range storage.InspectPages() {
+		types[info.Type]++
+	}
+	assert.Equal(t, 1, types[PageFreelistTrunk])
+	assert.Equal(t, 1, types[PageFreelistLeaf])
+	assert.Equal(t, 0, types[PageUnused])
+
+	// the same owners as the walk of Check
+	c := newChecker(storage)
+	c.walk()
+	assert.Equal(t, c.owners, storage.pageOwners())
+
+	rmSQLite(filename)
+}
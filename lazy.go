@@ -0,0 +1,77 @@
+package sqlite3utils
+
+// presentation is how the fields of the rows of a table are presented:
+// padded with the defaults of columns added after the row was written,
+// converted to the affinity of their columns and with REAL values
+// formatted.
+type presentation struct {
+	header     *Header
+	defaults   []*Data    // see padRows
+	affinities []Affinity // nil for fields as stored
+	realFormat RealFormat
+}
+
+// field decodes field i of a record.
+func (p *presentation) field(r *record, i int) *Data {
+	var d *Data
+	switch {
+	case i < r.len():
+		d = r.field(i)
+		if i < len(p.affinities) {
+			d = withAffinity(d, p.affinities[i])
+		}
+	case i < len(p.defaults):
+		d = p.defaults[i]
+	default:
+		return nullData()
+	}
+	if p.realFormat != RealSQLite && d.isFloat() {
+		formatted := *d
+		formatted.Value = FormatReal(d.float64(), p.realFormat)
+		d = &formatted
+	}
+	return d
+}
+
+// len returns the number of fields of a record once padded.
+func (p *presentation) len(r *record) int {
+	if r.len() < len(p.defaults) {
+		return len(p.defaults)
+	}
+	return r.len()
+}
+
+// decode decodes the fields of the rows not decoded yet.
+func (t *Table) decode() {
+	for _, e := range t.Entries {
+		if e.record == nil {
+			continue
+		}
+		e.Datas = make([]*Data, e.Len())
+		for i := range e.Datas {
+			e.Datas[i] = e.Field(i)
+		}
+		e.record = nil
+	}
+}
+
+// Len returns the number of fields of the row.
+func (e *Entry) Len() int {
+	if e.record == nil {
+		return len(e.Datas)
+	}
+	return e.present.len(e.record)
+}
+
+// Field returns field i of the row, or NULL past its end. The field of a
+// row loaded with LoadOptions.Lazy is decoded on each call, leaving the
+// other fields of the record alone.
+func (e *Entry) Field(i int) *Data {
+	if e.record == nil {
+		if i < len(e.Datas) {
+			return e.Datas[i]
+		}
+		return nullData()
+	}
+	return e.present.field(e.record, i)
+}
@@ -0,0 +1,89 @@
+package sqlite3utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadLazy(t *testing.T) {
+	filename := "/tmp/test_lazy.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"PRAGMA page_size = 512; CREATE TABLE item(id INTEGER PRIMARY KEY, price REAL, name TEXT, data BLOB);",
+		"INSERT INTO item VALUES (1, 3, \"one\", CAST(char(1, 2) AS BLOB)), (2, 0.1, " +
+			"\"" + strings.Repeat("long", 300) + "\", NULL);",
+		"ALTER TABLE item ADD COLUMN stock INTEGER DEFAULT 7;",
+		"INSERT INTO item VALUES (3, 2.5, \"three\", NULL, 0);",
+	})
+
+	options := LoadOptions{RealFormat: RealShortest}
+	eager, err := LoadWithOptions(filename, options)
+	assert.Nil(t, err)
+	options.Lazy = true
+	lazy, err := LoadWithOptions(filename, options)
+	assert.Nil(t, err)
+	assert.Equal(t, eager.Schemas, lazy.Schemas)
+
+	entries := lazy.Tables["item"].Entries
+	assert.Equal(t, 3, len(entries))
+	for i, e := range entries {
+		assert.Nil(t, e.Datas)
+		want := eager.Tables["item"].Entries[i]
+		assert.Equal(t, want.Rowid, e.Rowid)
+		assert.Equal(t, len(want.Datas), e.Len())
+		for j := 0; j < e.Len(); j++ {
+			assert.Equal(t, want.Datas[j], e.Field(j), "row %d field %d", i, j)
+		}
+		assert.True(t, e.Field(e.Len()).isNull())
+	}
+	// affinity, defaults and the REAL format are applied on access
+	assert.Equal(t, "3.0", entries[0].Field(1).Value)
+	assert.Equal(t, "7", entries[0].Field(4).Value)
+	assert.Equal(t, 1200, len(entries[1].Field(2).Value))
+
+	var out strings.Builder
+	assert.Nil(t, ExportCSV(lazy, "item", &out, CSVOptions{}))
+	var want strings.Builder
+	assert.Nil(t, ExportCSV(eager, "item", &want, CSVOptions{}))
+	assert.Equal(t, want.String(), out.String())
+
+	rmSQLite(filename)
+}
+
+func BenchmarkProjection(b *testing.B) {
+	filename := "/tmp/bench_projection.db"
+	rmSQLite(filename)
+	columns := []string{}
+	values := []string{}
+	for i := 0; i < 30; i++ {
+		columns = append(columns, "c"+string(rune('a'+i%26))+string(rune('a'+i/26)))
+		values = append(values, "printf(char(37, 100, 45, 37, 100), i, "+string(rune('0'+i%10))+")")
+	}
+	execSQLite(filename, []string{
+		"CREATE TABLE wide(" + strings.Join(columns, ", ") + ");",
+		"WITH RECURSIVE c(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM c WHERE i < 5000) INSERT INTO wide SELECT " +
+			strings.Join(values, ", ") + " FROM c;",
+	})
+	defer rmSQLite(filename)
+
+	for _, lazy := range []bool{false, true} {
+		name := "eager"
+		if lazy {
+			name = "lazy"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				storage, err := LoadWithOptions(filename, LoadOptions{Lazy: lazy})
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, e := range storage.Tables["wide"].Entries {
+					_ = e.Field(3)
+				}
+			}
+		})
+	}
+}
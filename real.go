@@ -0,0 +1,82 @@
+package sqlite3utils
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// RealFormat selects how REAL values are written as text.
+type RealFormat int
+
+// Real formats ...
+const (
+	// RealSQLite rounds to 15 significant digits like SQLite does when it
+	// converts a REAL to TEXT ("%!.15g"), so that the text matches the
+	// output of sqlite3.
+	RealSQLite RealFormat = iota
+	// RealShortest gives the shortest text that parses back to the same
+	// float64.
+	RealShortest
+)
+
+// FormatReal writes f as text. A decimal point is always included, so
+// that the text reads back as a REAL rather than an INTEGER.
+func FormatReal(f float64, format RealFormat) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+
+	if format == RealShortest {
+		if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+			return strconv.FormatFloat(f, 'f', 1, 64)
+		}
+		return withPoint(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return withPoint(strconv.FormatFloat(f, 'g', 15, 64))
+}
+
+// withPoint adds ".0" to a formatted float that has no decimal point.
+func withPoint(s string) string {
+	mantissa, exponent := s, ""
+	if i := strings.IndexByte(s, 'e'); i >= 0 {
+		mantissa, exponent = s[:i], s[i:]
+	}
+	if !strings.Contains(mantissa, ".") {
+		mantissa += ".0"
+	}
+	return mantissa + exponent
+}
+
+// ParseReal parses a REAL written by FormatReal or by sqlite3, including
+// "Inf" and out of range literals such as 9.0e+999.
+func ParseReal(s string) (float64, error) {
+	t := strings.TrimSpace(s)
+	switch strings.ToLower(t) {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+	if !numericPattern.MatchString(t) {
+		return 0, errors.New("Invalid real: " + strconv.Quote(s))
+	}
+	f, err := strconv.ParseFloat(t, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, err
+	}
+	return f, nil
+}
+
+// formatReals writes the Value of every REAL field in the given format.
+func (s *Storage) formatReals(format RealFormat) {
+	for _, table := range s.Tables {
+		table.present.realFormat = format
+	}
+}
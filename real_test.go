@@ -0,0 +1,66 @@
+package sqlite3utils
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatReal(t *testing.T) {
+	cases := []struct {
+		f        float64
+		sqlite   string
+		shortest string
+	}{
+		{0.1, "0.1", "0.1"},
+		{1.0 / 3, "0.333333333333333", "0.3333333333333333"},
+		{100, "100.0", "100.0"},
+		{1e20, "1.0e+20", "1.0e+20"},
+		{1e-5, "1.0e-05", "1.0e-05"},
+		{123456789012345678, "1.23456789012346e+17", "1.2345678901234568e+17"},
+		{0.30000000000000004, "0.3", "0.30000000000000004"},
+		{1e15, "1.0e+15", "1.0e+15"},
+		{1e14, "100000000000000.0", "100000000000000.0"},
+		{math.Inf(1), "Inf", "Inf"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.sqlite, FormatReal(c.f, RealSQLite))
+		assert.Equal(t, c.shortest, FormatReal(c.f, RealShortest))
+
+		f, err := ParseReal(c.shortest)
+		assert.Nil(t, err)
+		assert.Equal(t, c.f, f)
+	}
+
+	f, err := ParseReal("-9.0e+999")
+	assert.Nil(t, err)
+	assert.True(t, math.IsInf(f, -1))
+	_, err = ParseReal("0x1p-2")
+	assert.NotNil(t, err)
+}
+
+func TestLoadRealFormat(t *testing.T) {
+	filename := "/tmp/test_real.db"
+	rmSQLite(filename)
+	// the values of TestFormatReal, as sqlite3 prints them
+	execSQLite(filename, []string{
+		"CREATE TABLE r(x real, n integer);",
+		"INSERT INTO r VALUES (0.1, -1000), (1.0 / 3, -1), (0.1 + 0.2, -9223372036854775808);",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	values := []string{}
+	for _, e := range storage.Tables["r"].Entries {
+		values = append(values, e.Datas[0].Value+"|"+e.Datas[1].Value)
+	}
+	assert.Equal(t, "0.1|-1000 0.333333333333333|-1 0.3|-9223372036854775808", strings.Join(values, " "))
+
+	storage, err = LoadWithOptions(filename, LoadOptions{RealFormat: RealShortest})
+	assert.Nil(t, err)
+	assert.Equal(t, "0.30000000000000004", storage.Tables["r"].Entries[2].Datas[0].Value)
+
+	rmSQLite(filename)
+}
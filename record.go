@@ -0,0 +1,226 @@
+package sqlite3utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+// record is a payload with its header parsed. Its fields are decoded
+// when accessed.
+type record struct {
+	payload     []byte
+	serialTypes []int
+	offsets     []int
+}
+
+// parseRecord parses the header of a record and checks that every field
+// lies inside the payload, so that decoding a field cannot fail.
+func parseRecord(payload []byte) (*record, error) {
+	v, n, err := varintAt(payload, 0)
+	if err != nil {
+		return nil, corruptf(0, -1, -1, "bad record header size")
+	}
+	headerSize := int(v)
+	if headerSize > len(payload) || headerSize < n {
+		return nil, corruptf(0, -1, -1, "record header size %d out of range", headerSize)
+	}
+
+	r := &record{payload: payload, serialTypes: []int{}, offsets: []int{}}
+	total := n
+	dataShift := headerSize
+	for total < headerSize {
+		v, n, err = varintAt(payload[:headerSize], total)
+		if err != nil {
+			return nil, corruptf(0, -1, -1, "bad serial type in record header")
+		}
+		total += n
+
+		size := serialTypeSize(int(v))
+		if size < 0 || v > maxPayload {
+			return nil, corruptf(0, -1, -1, "field %d: Invalid serialType %d", len(r.offsets), v)
+		}
+		if dataShift+size > len(payload) {
+			return nil, corruptf(0, -1, -1, "field %d: No enough bytes! [%d < %d]", len(r.offsets), len(payload)-dataShift, size)
+		}
+		r.serialTypes = append(r.serialTypes, int(v))
+		r.offsets = append(r.offsets, dataShift)
+		dataShift += size
+	}
+	return r, nil
+}
+
+// len returns the number of fields.
+func (r *record) len() int {
+	return len(r.serialTypes)
+}
+
+// field decodes field i, which must exist.
+func (r *record) field(i int) *Data {
+	d, _ := takeData(r.payload[r.offsets[i]:], r.serialTypes[i])
+	return d
+}
+
+// datas decodes every field.
+func (r *record) datas() []*Data {
+	datas := make([]*Data, r.len())
+	for i := range datas {
+		datas[i] = r.field(i)
+	}
+	return datas
+}
+
+// decodeRecord decodes every field of a record.
+func decodeRecord(payload []byte) ([]*Data, error) {
+	r, err := parseRecord(payload)
+	if err != nil {
+		return nil, err
+	}
+	return r.datas(), nil
+}
+
+func (d *Data) isNull() bool {
+	return d.SerialType == 0 || d.SerialType == 10 || d.SerialType == 11
+}
+
+func (d *Data) isInt() bool {
+	return d.SerialType >= 1 && d.SerialType <= 6 || d.SerialType == 8 || d.SerialType == 9
+}
+
+func (d *Data) isFloat() bool {
+	return d.SerialType == 7
+}
+
+func (d *Data) isText() bool {
+	return d.SerialType >= 13 && d.SerialType%2 == 1
+}
+
+func (d *Data) isBlob() bool {
+	return d.SerialType >= 12 && d.SerialType%2 == 0
+}
+
+// intData returns an 8-byte integer field, serial type 6.
+func intData(v int64) *Data {
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, uint64(v))
+	return &Data{SerialType: 6, Bytes: bs, Value: strconv.FormatInt(v, 10), Len: 8}
+}
+
+// nullData returns a NULL field.
+func nullData() *Data {
+	return &Data{SerialType: 0, Bytes: []byte{}}
+}
+
+// int64 returns the value of an integer field.
+func (d *Data) int64() int64 {
+	switch d.SerialType {
+	case 8:
+		return 0
+	case 9:
+		return 1
+	}
+	ret := int64(0)
+	for i, b := range d.Bytes {
+		if i == 0 {
+			ret = int64(int8(b))
+		} else {
+			ret = ret<<8 | int64(b)
+		}
+	}
+	return ret
+}
+
+// float64 returns the value of a numeric field.
+func (d *Data) float64() float64 {
+	if d.isFloat() {
+		return math.Float64frombits(binary.BigEndian.Uint64(d.Bytes))
+	}
+	return float64(d.int64())
+}
+
+// Type returns the storage class of the field as typeof() names it:
+// "null", "integer", "real", "text" or "blob".
+func (d *Data) Type() string {
+	switch {
+	case d.isNull():
+		return "null"
+	case d.isInt():
+		return "integer"
+	case d.isFloat():
+		return "real"
+	case d.isText():
+		return "text"
+	}
+	return "blob"
+}
+
+// Int64 returns the value of an integer field, or of a real field with
+// the fraction dropped. It returns 0 for other fields.
+func (d *Data) Int64() int64 {
+	switch {
+	case d.isInt():
+		return d.int64()
+	case d.isFloat():
+		return int64(d.float64())
+	}
+	return 0
+}
+
+// Float64 returns the value of a real or integer field, so that the
+// integers REAL columns store for values without a fraction can be read
+// like the other values of the column. It returns 0 for other fields.
+func (d *Data) Float64() float64 {
+	if d.isInt() || d.isFloat() {
+		return d.float64()
+	}
+	return 0
+}
+
+// storageClass orders values the way SQLite compares them:
+// NULL < INTEGER and REAL < TEXT < BLOB.
+func (d *Data) storageClass() int {
+	switch {
+	case d.isNull():
+		return 0
+	case d.isInt() || d.isFloat():
+		return 1
+	case d.isText():
+		return 2
+	}
+	return 3
+}
+
+// compareData compares two values with the BINARY collation.
+func compareData(a, b *Data) int {
+	ca, cb := a.storageClass(), b.storageClass()
+	if ca != cb {
+		if ca < cb {
+			return -1
+		}
+		return 1
+	}
+
+	switch ca {
+	case 0:
+		return 0
+	case 1:
+		if a.isInt() && b.isInt() {
+			x, y := a.int64(), b.int64()
+			if x < y {
+				return -1
+			} else if x > y {
+				return 1
+			}
+			return 0
+		}
+		x, y := a.float64(), b.float64()
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+		return 0
+	}
+	return bytes.Compare(a.Bytes, b.Bytes)
+}
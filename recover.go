@@ -0,0 +1,90 @@
+package sqlite3utils
+
+// LoadOptions ...
+type LoadOptions struct {
+	// Recover skips corrupt pages, cells and overflow chains instead of
+	// failing, and returns every row that can still be decoded. What was
+	// skipped is listed in Storage.Diagnostics.
+	Recover bool
+
+	// Raw leaves fields as stored instead of giving back as reals the
+	// integers that REAL columns store for values without a fraction.
+	Raw bool
+
+	// RealFormat is how REAL fields are written in Data.Value.
+	RealFormat RealFormat
+
+	// Lazy keeps the records of the rows undecoded: Entry.Datas is nil
+	// and Entry.Field decodes a single field when it is read, which is
+	// much cheaper when only a few columns of wide tables are needed.
+	Lazy bool
+
+	// ParallelTrees parses the pages of each b-tree in a goroutine of
+	// its own, starting from the root pages listed in sqlite_master,
+	// and decodes the rows of the tables in parallel too, up to
+	// GOMAXPROCS goroutines at a time. Workers is then ignored.
+	ParallelTrees bool
+
+	// Workers is the number of goroutines parsing the pages, each a
+	// contiguous range of them. The result is the same as parsing them
+	// one after the other, which 0 and 1 do.
+	Workers int
+}
+
+// diagnostics collects the corruption skipped in Recover mode. A nil
+// *diagnostics skips nothing.
+type diagnostics struct {
+	errs []*CorruptError
+}
+
+// skip records err and returns true if it is corruption that can be
+// skipped.
+func (d *diagnostics) skip(err error) bool {
+	corrupt, ok := err.(*CorruptError)
+	if d == nil || !ok {
+		return false
+	}
+	d.errs = append(d.errs, corrupt)
+	return true
+}
+
+func (d *diagnostics) list() []*CorruptError {
+	if d == nil {
+		return nil
+	}
+	return d.errs
+}
+
+// LoadWithOptions ...
+func LoadWithOptions(path string, options LoadOptions) (*Storage, error) {
+	cnt, wal, err := readDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	if wal != nil {
+		cnt = wal.overlay(cnt, wal.mxFrame)
+	}
+	return loadImage(path, cnt, wal, options)
+}
+
+// loadImage parses the database image cnt as the options say.
+func loadImage(path string, cnt []byte, wal *WAL, options LoadOptions) (*Storage, error) {
+	var diag *diagnostics
+	if options.Recover {
+		diag = &diagnostics{errs: []*CorruptError{}}
+	}
+	storage, err := parseStorage(path, cnt, wal, diag, options.Workers, options.ParallelTrees)
+	if err != nil {
+		return nil, err
+	}
+	if !options.Raw {
+		storage.presentAffinity()
+	}
+	if options.RealFormat != RealSQLite {
+		storage.formatReals(options.RealFormat)
+	}
+	if !options.Lazy {
+		storage.decodeRows(options.ParallelTrees)
+	}
+	return storage, nil
+}
@@ -0,0 +1,44 @@
+package sqlite3utils
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecover(t *testing.T) {
+	filename := "/tmp/test_recover.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"CREATE TABLE person(id integer, name text);",
+		"INSERT INTO person VALUES (1, \"hoge\");",
+		"INSERT INTO person VALUES (2, \"foo\");",
+		"INSERT INTO person VALUES (3, \"bar\");",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(storage.Diagnostics))
+	pageSize := storage.Header.pageSize
+
+	// Break the second cell of the person table.
+	cnt, _ := ioutil.ReadFile(filename)
+	cnt[pageSize+8+2] = 0xff
+	cnt[pageSize+8+3] = 0xff
+	ioutil.WriteFile(filename, cnt, 0644)
+
+	_, err = Load(filename)
+	assert.NotNil(t, err)
+
+	storage, err = LoadWithOptions(filename, LoadOptions{Recover: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(storage.Tables["person"].Entries))
+	assert.Equal(t, "hoge", storage.Tables["person"].Entries[0].Datas[1].Value)
+	assert.Equal(t, "bar", storage.Tables["person"].Entries[1].Datas[1].Value)
+	assert.Equal(t, 1, len(storage.Diagnostics))
+	assert.Equal(t, 2, storage.Diagnostics[0].Page)
+	assert.Equal(t, 1, storage.Diagnostics[0].Cell)
+
+	rmSQLite(filename)
+}
@@ -0,0 +1,524 @@
+package sqlite3utils
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Column is a column of a table or an index.
+type Column struct {
+	Name       string
+	Type       string // declared type, empty if none
+	PrimaryKey bool
+	NotNull    bool
+	Default    string // DEFAULT expression as written, empty if none
+	Collate    string // collating sequence name, empty for BINARY
+	Desc       bool   // descending index column
+
+	// descKey is set for PRIMARY KEY DESC in the column definition,
+	// which keeps an INTEGER column from being an alias for the rowid.
+	descKey bool
+
+	// Expression is set instead of Name for an index on an expression.
+	Expression string
+}
+
+// Schema is an object described by sqlite_master.
+type Schema struct {
+	Type      string // "table", "index", "view" or "trigger"
+	Name      string
+	TableName string
+	RootPage  int
+	SQL       string
+
+	Columns      []*Column
+	PrimaryKey   []int // positions of the PRIMARY KEY columns in key order
+	Unique       bool  // UNIQUE index
+	Partial      bool  // index with a WHERE clause
+	WithoutRowid bool
+}
+
+// Column returns the position of the named column, or -1.
+func (s *Schema) Column(name string) int {
+	for i, c := range s.Columns {
+		if strings.EqualFold(c.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// RowidColumn returns the position of the INTEGER PRIMARY KEY column,
+// which is an alias for the rowid and stored as NULL in records, or -1.
+func (s *Schema) RowidColumn() int {
+	if s.WithoutRowid {
+		return -1
+	}
+	ret := -1
+	for i, c := range s.Columns {
+		if c.PrimaryKey {
+			if ret >= 0 || !strings.EqualFold(c.Type, "integer") || c.descKey {
+				return -1
+			}
+			ret = i
+		}
+	}
+	return ret
+}
+
+// Affinity is the type affinity of a column.
+type Affinity int
+
+// Affinities ...
+const (
+	AffinityBlob Affinity = iota
+	AffinityText
+	AffinityNumeric
+	AffinityInteger
+	AffinityReal
+)
+
+// Affinity derives the affinity of the column from its declared type.
+// sqlite3/src/insert.c:sqlite3AffinityType
+func (c *Column) Affinity() Affinity {
+	t := strings.ToUpper(c.Type)
+	switch {
+	case strings.Contains(t, "INT"):
+		return AffinityInteger
+	case strings.Contains(t, "CHAR") || strings.Contains(t, "CLOB") || strings.Contains(t, "TEXT"):
+		return AffinityText
+	case strings.Contains(t, "BLOB") || t == "":
+		return AffinityBlob
+	case strings.Contains(t, "REAL") || strings.Contains(t, "FLOA") || strings.Contains(t, "DOUB"):
+		return AffinityReal
+	}
+	return AffinityNumeric
+}
+
+// defaultValue evaluates the DEFAULT expression of the column to nil,
+// int64, float64, string or []byte. Only literals are evaluated, which
+// is all ALTER TABLE ADD COLUMN allows; other expressions give nil.
+func (c *Column) defaultValue() interface{} {
+	tokens := tokenize(c.Default)
+	for len(tokens) > 2 && tokens[0].isSymbol("(") && skipGroup(tokens, 0) == len(tokens) {
+		tokens = tokens[1 : len(tokens)-1]
+	}
+	sign := ""
+	if len(tokens) == 2 && (tokens[0].isSymbol("-") || tokens[0].isSymbol("+")) {
+		sign = tokens[0].text
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) == 2 && sign == "" && (tokens[0].is("x") && tokens[0].end == tokens[1].start) && tokens[1].kind == tokenString {
+		bs, err := hex.DecodeString(tokens[1].text)
+		if err != nil {
+			return nil
+		}
+		return bs
+	}
+	if len(tokens) != 1 {
+		return nil
+	}
+
+	t := tokens[0]
+	switch {
+	case t.kind == tokenNumber:
+		if strings.HasPrefix(strings.ToLower(t.text), "0x") {
+			n, err := strconv.ParseUint(t.text[2:], 16, 64)
+			if err != nil {
+				return nil
+			}
+			if sign == "-" {
+				return -int64(n)
+			}
+			return int64(n)
+		}
+		if v, ok := parseNumeric(sign + t.text); ok {
+			return v
+		}
+	case sign != "":
+	case t.kind == tokenString:
+		return t.text
+	case t.kind == tokenIdent && c.Default[t.start] == '"':
+		// a string in double quotes
+		return t.text
+	case t.is("true"):
+		return int64(1)
+	case t.is("false"):
+		return int64(0)
+	}
+	return nil
+}
+
+const (
+	tokenIdent = iota
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+type token struct {
+	kind  int
+	text  string // unquoted for identifiers
+	start int
+	end   int
+}
+
+func (t *token) is(keyword string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, keyword)
+}
+
+func (t *token) isSymbol(symbol string) bool {
+	return t.kind == tokenSymbol && t.text == symbol
+}
+
+func tokenize(sql string) []*token {
+	tokens := []*token{}
+	i := 0
+	for i < len(sql) {
+		c := sql[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 4
+			}
+			continue
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			closer := c
+			if c == '[' {
+				closer = ']'
+			}
+			i++
+			text := ""
+			for i < len(sql) {
+				if sql[i] == closer {
+					if closer != ']' && i+1 < len(sql) && sql[i+1] == closer {
+						text += string(closer)
+						i += 2
+						continue
+					}
+					break
+				}
+				text += string(sql[i])
+				i++
+			}
+			if i < len(sql) {
+				i++
+			}
+			kind := tokenIdent
+			if c == '\'' {
+				kind = tokenString
+			}
+			tokens = append(tokens, &token{kind, text, start, i})
+		case isIdentChar(c) && !(c >= '0' && c <= '9'):
+			for i < len(sql) && isIdentChar(sql[i]) {
+				i++
+			}
+			tokens = append(tokens, &token{tokenIdent, sql[start:i], start, i})
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(sql) && sql[i+1] >= '0' && sql[i+1] <= '9':
+			for i < len(sql) && (isIdentChar(sql[i]) || sql[i] == '.' ||
+				(sql[i] == '+' || sql[i] == '-') && (sql[i-1] == 'e' || sql[i-1] == 'E')) {
+				i++
+			}
+			tokens = append(tokens, &token{tokenNumber, sql[start:i], start, i})
+		default:
+			i++
+			tokens = append(tokens, &token{tokenSymbol, string(c), start, i})
+		}
+	}
+	return tokens
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// skipGroup returns the position right after the parenthesized group
+// opening at tokens[i].
+func skipGroup(tokens []*token, i int) int {
+	depth := 0
+	for ; i < len(tokens); i++ {
+		if tokens[i].isSymbol("(") {
+			depth++
+		} else if tokens[i].isSymbol(")") {
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// splitList splits tokens on top-level commas.
+func splitList(tokens []*token) [][]*token {
+	ret := [][]*token{}
+	depth := 0
+	start := 0
+	for i, t := range tokens {
+		if t.kind != tokenSymbol {
+			continue
+		}
+		switch t.text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				ret = append(ret, tokens[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(ret, tokens[start:])
+}
+
+var columnConstraints = []string{
+	"constraint", "primary", "not", "null", "unique", "check", "default",
+	"collate", "references", "generated", "as",
+}
+
+var tableConstraints = []string{"constraint", "primary", "unique", "check", "foreign"}
+
+func isKeyword(t *token, keywords []string) bool {
+	for _, k := range keywords {
+		if t.is(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSchema fills the columns of a table or an index from its SQL.
+// Views and triggers are left without columns.
+func parseSchema(schema *Schema) {
+	tokens := tokenize(schema.SQL)
+	if len(tokens) < 3 || !tokens[0].is("create") {
+		return
+	}
+
+	i := 1
+	for i < len(tokens) && (tokens[i].is("temp") || tokens[i].is("temporary") || tokens[i].is("virtual")) {
+		i++
+	}
+	if i < len(tokens) && tokens[i].is("unique") {
+		schema.Unique = true
+		i++
+	}
+	if i >= len(tokens) {
+		return
+	}
+
+	if tokens[i].is("table") {
+		parseCreateTable(schema, tokens[i+1:])
+	} else if tokens[i].is("index") {
+		parseCreateIndex(schema, tokens[i+1:])
+	}
+}
+
+func parseCreateTable(schema *Schema, tokens []*token) {
+	open := 0
+	for open < len(tokens) && !tokens[open].isSymbol("(") {
+		open++
+	}
+	close := skipGroup(tokens, open)
+	if close > len(tokens) || close-1 <= open {
+		return
+	}
+
+	for i := close; i+1 < len(tokens); i++ {
+		if tokens[i].is("without") && tokens[i+1].is("rowid") {
+			schema.WithoutRowid = true
+		}
+	}
+
+	for _, def := range splitList(tokens[open+1 : close-1]) {
+		if len(def) == 0 {
+			continue
+		}
+		if isKeyword(def[0], tableConstraints) {
+			parseTableConstraint(schema, def)
+			continue
+		}
+		column := parseColumnDef(schema.SQL, def)
+		if column.PrimaryKey {
+			schema.PrimaryKey = append(schema.PrimaryKey, len(schema.Columns))
+		}
+		schema.Columns = append(schema.Columns, column)
+	}
+}
+
+func parseColumnDef(sql string, def []*token) *Column {
+	column := &Column{Name: def[0].text}
+
+	i := 1
+	typeStart := i
+	for i < len(def) && !isKeyword(def[i], columnConstraints) {
+		if def[i].isSymbol("(") {
+			i = skipGroup(def, i)
+		} else {
+			i++
+		}
+	}
+	if i > typeStart {
+		column.Type = sql[def[typeStart].start:def[i-1].end]
+	}
+
+	for i < len(def) {
+		t := def[i]
+		i++
+		switch {
+		case t.is("primary"):
+			column.PrimaryKey = true
+			if i+1 < len(def) && def[i+1].is("desc") {
+				column.Desc = true
+				column.descKey = true
+			}
+		case t.is("not"):
+			if i < len(def) && def[i].is("null") {
+				column.NotNull = true
+				i++
+			}
+		case t.is("collate"):
+			if i < len(def) {
+				column.Collate = def[i].text
+				i++
+			}
+		case t.is("default"):
+			if i >= len(def) {
+				break
+			}
+			start := i
+			if def[i].isSymbol("(") {
+				i = skipGroup(def, i)
+			} else if (def[i].isSymbol("-") || def[i].isSymbol("+")) && i+1 < len(def) {
+				i += 2
+			} else {
+				i++
+			}
+			column.Default = sql[def[start].start:def[i-1].end]
+		case t.isSymbol("("):
+			i = skipGroup(def, i-1)
+		}
+	}
+	return column
+}
+
+func parseTableConstraint(schema *Schema, def []*token) {
+	i := 0
+	if def[i].is("constraint") {
+		i += 2
+	}
+	if i >= len(def) || !def[i].is("primary") {
+		return
+	}
+	for i < len(def) && !def[i].isSymbol("(") {
+		i++
+	}
+	end := skipGroup(def, i)
+	if i >= len(def) || end-1 <= i {
+		return
+	}
+	for _, part := range splitList(def[i+1 : end-1]) {
+		if len(part) == 0 {
+			continue
+		}
+		if n := schema.Column(part[0].text); n >= 0 {
+			if !schema.Columns[n].PrimaryKey {
+				schema.PrimaryKey = append(schema.PrimaryKey, n)
+			}
+			schema.Columns[n].PrimaryKey = true
+			for _, t := range part[1:] {
+				if t.is("desc") {
+					schema.Columns[n].Desc = true
+				}
+			}
+		}
+	}
+}
+
+func parseCreateIndex(schema *Schema, tokens []*token) {
+	open := 0
+	for open < len(tokens) && !tokens[open].isSymbol("(") {
+		open++
+	}
+	close := skipGroup(tokens, open)
+	if close > len(tokens) || close-1 <= open {
+		return
+	}
+
+	for _, t := range tokens[close:] {
+		if t.is("where") {
+			schema.Partial = true
+		}
+	}
+
+	for _, part := range splitList(tokens[open+1 : close-1]) {
+		if len(part) == 0 {
+			continue
+		}
+		column := &Column{}
+		end := len(part)
+		for j := len(part) - 1; j >= 0; j-- {
+			if part[j].is("asc") || part[j].is("desc") {
+				column.Desc = part[j].is("desc")
+				end = j
+			} else if j > 0 && part[j-1].is("collate") {
+				column.Collate = part[j].text
+				end = j - 1
+			} else {
+				break
+			}
+		}
+		if end == 1 && (part[0].kind == tokenIdent || part[0].kind == tokenString) {
+			column.Name = part[0].text
+		} else if end > 0 {
+			column.Expression = schema.SQL[part[0].start:part[end-1].end]
+		}
+		schema.Columns = append(schema.Columns, column)
+	}
+}
+
+// masterText returns a text field of sqlite_master, decoded from the
+// encoding of the database, or the value of another field.
+func masterText(d *Data, encoding int) string {
+	if d.isText() {
+		return d.text(encoding)
+	}
+	return d.Value
+}
+
+// makeSchemas reads the objects listed in sqlite_master.
+func makeSchemas(master *Table, encoding int) []*Schema {
+	schemas := []*Schema{}
+	for _, e := range master.Entries {
+		if len(e.Datas) < 5 {
+			continue
+		}
+		rootPage, _ := strconv.Atoi(e.Datas[3].Value)
+		schema := &Schema{
+			Type:      masterText(e.Datas[0], encoding),
+			Name:      masterText(e.Datas[1], encoding),
+			TableName: masterText(e.Datas[2], encoding),
+			RootPage:  rootPage,
+			SQL:       masterText(e.Datas[4], encoding),
+		}
+		parseSchema(schema)
+		schemas = append(schemas, schema)
+	}
+	return schemas
+}
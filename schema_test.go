@@ -0,0 +1,111 @@
+package sqlite3utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCreateTable(t *testing.T) {
+	schema := &Schema{SQL: `CREATE TABLE "my table" (
+		id INTEGER PRIMARY KEY,
+		[name] varchar(10) NOT NULL COLLATE NOCASE, -- comment
+		price REAL DEFAULT -1.5,
+		created TEXT DEFAULT (datetime('now')),
+		note,
+		CONSTRAINT uq UNIQUE (name, price)
+	)`}
+	parseSchema(schema)
+
+	assert.Equal(t, 5, len(schema.Columns))
+	assert.Equal(t, "id", schema.Columns[0].Name)
+	assert.True(t, schema.Columns[0].PrimaryKey)
+	assert.Equal(t, 0, schema.RowidColumn())
+	assert.Equal(t, "name", schema.Columns[1].Name)
+	assert.Equal(t, "varchar(10)", schema.Columns[1].Type)
+	assert.Equal(t, "NOCASE", schema.Columns[1].Collate)
+	assert.True(t, schema.Columns[1].NotNull)
+	assert.Equal(t, "-1.5", schema.Columns[2].Default)
+	assert.Equal(t, "(datetime('now'))", schema.Columns[3].Default)
+	assert.Equal(t, "", schema.Columns[4].Type)
+	assert.False(t, schema.WithoutRowid)
+}
+
+func TestParseWithoutRowid(t *testing.T) {
+	schema := &Schema{SQL: "CREATE TABLE kv(k TEXT, v BLOB, PRIMARY KEY (k DESC)) WITHOUT ROWID"}
+	parseSchema(schema)
+
+	assert.Equal(t, 2, len(schema.Columns))
+	assert.True(t, schema.Columns[0].PrimaryKey)
+	assert.True(t, schema.Columns[0].Desc)
+	assert.True(t, schema.WithoutRowid)
+	assert.Equal(t, -1, schema.RowidColumn())
+}
+
+func TestRowidColumnDesc(t *testing.T) {
+	// DESC only keeps a column from being the rowid in the column
+	// definition
+	schema := &Schema{SQL: "CREATE TABLE t(x INTEGER PRIMARY KEY DESC, y TEXT)"}
+	parseSchema(schema)
+	assert.True(t, schema.Columns[0].Desc)
+	assert.Equal(t, -1, schema.RowidColumn())
+
+	schema = &Schema{SQL: "CREATE TABLE t(x INTEGER, y TEXT, PRIMARY KEY(x DESC))"}
+	parseSchema(schema)
+	assert.True(t, schema.Columns[0].Desc)
+	assert.Equal(t, 0, schema.RowidColumn())
+
+	filename := "/tmp/test_rowid_desc.db"
+	rmSQLite(filename)
+	execSQLite(filename, []string{
+		"CREATE TABLE t(x INTEGER, y TEXT, PRIMARY KEY(x DESC)); INSERT INTO t VALUES (9, \"b\"), (5, \"a\");",
+	})
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	entries := storage.Tables["t"].Entries
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, int64(5), int64(entries[0].Rowid))
+	assert.Equal(t, []string{"CREATE TABLE t(x INTEGER, y TEXT, PRIMARY KEY(x DESC));",
+		"INSERT INTO t VALUES(5,'a');", "INSERT INTO t VALUES(9,'b');"},
+		strings.Split(dumpString(t, filename), "\n")[2:5])
+	rmSQLite(filename)
+}
+
+func TestParseCreateIndex(t *testing.T) {
+	schema := &Schema{SQL: "CREATE UNIQUE INDEX idx ON t(a COLLATE nocase DESC, lower(b), c) WHERE c > 0"}
+	parseSchema(schema)
+
+	assert.True(t, schema.Unique)
+	assert.True(t, schema.Partial)
+	assert.Equal(t, 3, len(schema.Columns))
+	assert.Equal(t, "a", schema.Columns[0].Name)
+	assert.Equal(t, "nocase", schema.Columns[0].Collate)
+	assert.True(t, schema.Columns[0].Desc)
+	assert.Equal(t, "lower(b)", schema.Columns[1].Expression)
+	assert.Equal(t, "c", schema.Columns[2].Name)
+}
+
+func TestDefaultValue(t *testing.T) {
+	cases := []struct {
+		expression string
+		expected   interface{}
+	}{
+		{"", nil},
+		{"5", int64(5)},
+		{"-1.5", -1.5},
+		{"(+7)", int64(7)},
+		{"0x10", int64(16)},
+		{"'it''s'", "it's"},
+		{`"quoted"`, "quoted"},
+		{"X'6869'", []byte("hi")},
+		{"NULL", nil},
+		{"TRUE", int64(1)},
+		{"CURRENT_TIMESTAMP", nil},
+		{"(1 + 2)", nil},
+	}
+	for _, c := range cases {
+		column := &Column{Default: c.expression}
+		assert.Equal(t, c.expected, column.defaultValue(), c.expression)
+	}
+}
@@ -0,0 +1,67 @@
+package sqlite3utils
+
+import (
+	"fmt"
+)
+
+// Commit is a transaction committed in the WAL file.
+type Commit struct {
+	Frame  int   // 1-based index of the commit frame in the WAL
+	DbSize int   // database size in pages after the commit
+	Pages  []int // pages written by the transaction, in frame order
+}
+
+func walCommits(wal *WAL) []*Commit {
+	commits := []*Commit{}
+	if wal == nil {
+		return commits
+	}
+
+	pages := []int{}
+	for i, frame := range wal.Frames[:wal.mxFrame] {
+		pages = append(pages, frame.pageNum)
+		if frame.isCommit() {
+			commits = append(commits, &Commit{
+				Frame:  i + 1,
+				DbSize: frame.dbSize,
+				Pages:  pages,
+			})
+			pages = []int{}
+		}
+	}
+	return commits
+}
+
+// Commits lists the valid commits in the WAL file of the database at
+// path, oldest first.
+func Commits(path string) ([]*Commit, error) {
+	wal, err := loadWAL(path + "-wal")
+	if err != nil {
+		return nil, err
+	}
+	return walCommits(wal), nil
+}
+
+// LoadCommit loads the database at path as it was right after the
+// commit ending at the given WAL frame. A frame of 0 ignores the WAL and
+// loads the database file alone.
+func LoadCommit(path string, frame int) (*Storage, error) {
+	return LoadCommitWithOptions(path, frame, LoadOptions{})
+}
+
+// LoadCommitWithOptions is LoadCommit with the options of
+// LoadWithOptions.
+func LoadCommitWithOptions(path string, frame int, options LoadOptions) (*Storage, error) {
+	cnt, wal, err := readDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	if frame == 0 {
+		wal = nil
+	} else if wal == nil || frame < 0 || frame > wal.mxFrame || !wal.Frames[frame-1].isCommit() {
+		return nil, fmt.Errorf("No commit at WAL frame %d", frame)
+	} else {
+		cnt = wal.overlay(cnt, frame)
+	}
+	return loadImage(path, cnt, wal, options)
+}
@@ -0,0 +1,75 @@
+package sqlite3utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCommit(t *testing.T) {
+	filename := "/tmp/test_snapshot.db"
+	rmSQLite(filename)
+	rmSQLite(filename + "-wal")
+	rmSQLite(filename + "-shm")
+
+	execSQLite(filename, []string{
+		"PRAGMA journal_mode=WAL;",
+		noCheckpoint + "CREATE TABLE person(id integer, name text);",
+		noCheckpoint + "INSERT INTO person VALUES (1, \"hogehoge\");",
+		noCheckpoint + "UPDATE person SET name = \"foofoofoo\";",
+	})
+
+	commits, err := Commits(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(commits))
+
+	storage, err := LoadCommit(filename, commits[0].Frame)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(storage.Tables["person"].Entries))
+
+	storage, err = LoadCommit(filename, commits[1].Frame)
+	assert.Nil(t, err)
+	assert.Equal(t, "hogehoge", storage.Tables["person"].Entries[0].Datas[1].Value)
+
+	storage, err = LoadCommit(filename, commits[2].Frame)
+	assert.Nil(t, err)
+	assert.Equal(t, "foofoofoo", storage.Tables["person"].Entries[0].Datas[1].Value)
+
+	storage, err = LoadCommit(filename, 0)
+	assert.Nil(t, err)
+	_, ok := storage.Tables["person"]
+	assert.False(t, ok)
+
+	_, err = LoadCommit(filename, commits[2].Frame+1)
+	assert.NotNil(t, err)
+
+	rmSQLite(filename)
+	rmSQLite(filename + "-wal")
+	rmSQLite(filename + "-shm")
+}
+
+func TestLoadCommitRaw(t *testing.T) {
+	filename := "/tmp/test_snapshot_raw.db"
+	rmSQLite(filename)
+	rmSQLite(filename + "-wal")
+	rmSQLite(filename + "-shm")
+
+	execSQLite(filename, []string{
+		"PRAGMA journal_mode=WAL;",
+		noCheckpoint + "CREATE TABLE item(price real); INSERT INTO item VALUES (3);",
+	})
+	commits, err := Commits(filename)
+	assert.Nil(t, err)
+
+	storage, err := LoadCommit(filename, commits[len(commits)-1].Frame)
+	assert.Nil(t, err)
+	assert.True(t, storage.Tables["item"].Entries[0].Datas[0].isFloat())
+
+	storage, err = LoadCommitWithOptions(filename, commits[len(commits)-1].Frame, LoadOptions{Raw: true})
+	assert.Nil(t, err)
+	assert.True(t, storage.Tables["item"].Entries[0].Datas[0].isInt())
+
+	rmSQLite(filename)
+	rmSQLite(filename + "-wal")
+	rmSQLite(filename + "-shm")
+}
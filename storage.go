@@ -8,7 +8,11 @@ import (
 	"io/ioutil"
 	"math"
 	"os"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	u "github.com/kawakami-o3/undergo"
 )
@@ -20,10 +24,6 @@ const (
 	leafTable     = 13 // 0x0d
 )
 
-func warn(msg ...interface{}) {
-	fmt.Println(append([]interface{}{"[WARN]"}, msg...)...)
-}
-
 func debugPp(msg ...interface{}) {
 	//pp.Println(msg)
 }
@@ -56,15 +56,20 @@ func toInt(bytes []byte) int {
 	return ret
 }
 
+// fetch returns size bytes at offset, or the rest of bytes if size is 0.
+// The result is cut short at the end of bytes instead of panicking.
 func fetch(bytes []byte, offset, size int) []byte {
-	if size == 0 {
+	if offset < 0 || offset > len(bytes) || size < 0 {
+		return []byte{}
+	}
+	if size == 0 || offset+size > len(bytes) {
 		return bytes[offset:]
 	}
 	return bytes[offset : offset+size]
 }
 
 func fetchInt(bytes []byte, offset, size int) int {
-	return toInt(bytes[offset : offset+size])
+	return toInt(fetch(bytes, offset, size))
 }
 
 func fetchCopy(bytes []byte, offset, size int) []byte {
@@ -73,173 +78,110 @@ func fetchCopy(bytes []byte, offset, size int) []byte {
 	return ret
 }
 
-func parseInteriorIndexPage(page *Page, bytes []byte, pageNum int, header *Header) *Page {
-
-	pageSize := header.pageSize
-	pageOffset := pageSize * (pageNum - 1)
-	for _, cellPtr := range page.cellPtrs {
-		cellOffset := cellPtr + pageOffset
-
-		var v uint64
-		var i uint
-		delta := 0
-		payloadSize := 0
-
-		childPageNumber := toInt(fetch(bytes, cellOffset, 4))
-		delta += 4
-		debug("0x02:offset:", u.S16(cellOffset), page.startCellPtr, u.S16(pageSize*(pageNum-1)))
-		debug("0x02:child:", childPageNumber, fetch(bytes, cellOffset, 4))
-
-		//v, i = decodeVarint32(fetch(bytes, cellOffset+delta, 8))
-		v, i = decodeVarint(fetch(bytes, cellOffset+delta, 8))
-		delta += int(i)
-		payloadSize = int(v)
-		//debug("payload size:", payloadSize, i, fetch(bytes, cellOffset, payloadSize+4))
-		debug("0x02:payld:", payloadSize, i, fetch(bytes, cellOffset+delta, 8))
-
-		//payloadBytes := fetch(bytes, cellOffset+delta, payloadSize)
-		payloadBytes := fetch(bytes, cellOffset+delta, payloadSize)
-
-		v, i = decodeVarint(payloadBytes)
-		headerSize := int(v)
-
-		//headerInts := []uint64{}
-		total := int(i)
-
-		dataShift := headerSize
-		row := &Row{
-			childPageNumber: childPageNumber,
-			datas:           []*Data{},
-		}
-		//debug("total vs headerSize", total, headerSize)
-		for total < headerSize {
-			v, i = decodeVarint(payloadBytes[total:])
-			if i == 0 {
-				warn("internal error")
-				return nil
-			}
-			total += int(i)
+// parseRecordCell reads the payload of the index-th cell on the page and
+// parses its record header.
+func parseRecordCell(page *Page, bytes []byte, header *Header, index int) (*cell, *record, error) {
+	c, err := parseCell(bytes, page, header, index)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.overflow != 0 {
+		page.isOverflow = true
+	}
 
-			//headerInts = append(headerInts, v)
+	payload, err := readPayload(bytes, header, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := parseRecord(payload)
+	if err != nil {
+		return nil, nil, c.corruptf("%s", reason(err))
+	}
+	return c, r, nil
+}
 
-			serialType := int(v)
+func parseInteriorIndexPage(page *Page, bytes []byte, pageNum int, header *Header, diag *diagnostics) (*Page, error) {
+	/*
+		Index B-Tree Interior Cell (header 0x02):
+			* A 4-byte big-endian page number which is the left child pointer.
+			* A varint which is the total number of bytes of key payload, including any overflow
+			* The initial portion of the payload that does not spill to overflow pages.
+			* A 4-byte big-endian integer page number for the first page of
+				the overflow page list - omitted if all payload fits on the b-tree page.
+	*/
 
-			if len(payloadBytes) < dataShift {
-				warn("[", page.pageNum, "]", "dataShift too large", len(payloadBytes), dataShift)
-				//debugPp(page)
-			}
-			d, err := takeData(fetch(payloadBytes, dataShift, 0), serialType)
-			if err != nil {
-				warn(err)
+	for i := range page.cellPtrs {
+		c, r, err := parseRecordCell(page, bytes, header, i)
+		if err != nil {
+			if diag.skip(err) {
+				continue
 			}
-
-			row.datas = append(row.datas, d)
-			dataShift += len(d.Bytes)
+			return nil, err
 		}
+		debug("0x02:child:", c.child, "payld:", c.payloadSize, u.S16(c.offset))
 
-		page.rows = append(page.rows, row)
-		//debug("offset:", payloadSize, delta)
-		cellOffset += payloadSize + delta
+		page.rows = append(page.rows, &Row{
+			childPageNumber: c.child,
+			datas:           r.datas(),
+		})
 	}
 
-	return page
+	return page, nil
 }
 
-func parseLeafIndexPage(page *Page, bytes []byte, pageNum int, header *Header) *Page {
-
-	pageSize := header.pageSize
-	pageOffset := pageSize * (pageNum - 1)
-	for _, cellPtr := range page.cellPtrs {
-		cellOffset := cellPtr + pageOffset
-
-		var v uint64
-		var i uint
-		delta := 0
-		payloadSize := 0
-
-		v, i = decodeVarint32(fetch(bytes, cellOffset, 8))
-		delta += int(i)
-		payloadSize = int(v)
-		//debug("payload size:", payloadSize, i, fetch(bytes, cellOffset, payloadSize+4))
-		debug("payld:", payloadSize, i, fetch(bytes, cellOffset, 8))
-
-		//payloadBytes := fetch(bytes, cellOffset+delta, payloadSize)
-		payloadBytes := fetch(bytes, cellOffset+delta, payloadSize)
-
-		v, i = decodeVarint(payloadBytes)
-		headerSize := int(v)
-
-		//headerInts := []uint64{}
-		total := int(i)
-
-		dataShift := headerSize
-		row := &Row{
-			datas: []*Data{},
-		}
-		//debug("total vs headerSize", total, headerSize)
-		for total < headerSize {
-			v, i = decodeVarint(payloadBytes[total:])
-			if i == 0 {
-				warn("internal error")
-				return nil
-			}
-			total += int(i)
-
-			//headerInts = append(headerInts, v)
-
-			serialType := int(v)
+func parseLeafIndexPage(page *Page, bytes []byte, pageNum int, header *Header, diag *diagnostics) (*Page, error) {
+	/*
+		Index B-Tree Leaf Cell (header 0x0a):
+			* A varint which is the total number of bytes of key payload, including any overflow
+			* The initial portion of the payload that does not spill to overflow pages.
+			* A 4-byte big-endian integer page number for the first page of
+				the overflow page list - omitted if all payload fits on the b-tree page.
+	*/
 
-			if len(payloadBytes) < dataShift {
-				warn("[", page.pageNum, "]", "dataShift too large", len(payloadBytes), dataShift)
-			}
-			d, err := takeData(fetch(payloadBytes, dataShift, 0), serialType)
-			if err != nil {
-				warn(err)
+	for i := range page.cellPtrs {
+		_, r, err := parseRecordCell(page, bytes, header, i)
+		if err != nil {
+			if diag.skip(err) {
+				continue
 			}
-
-			row.datas = append(row.datas, d)
-			dataShift += len(d.Bytes)
+			return nil, err
 		}
 
-		page.rows = append(page.rows, row)
-		//debug("offset:", payloadSize, delta)
-		cellOffset += payloadSize + delta
+		page.rows = append(page.rows, &Row{
+			datas: r.datas(),
+		})
 	}
 
-	return page
+	return page, nil
 }
 
-func parseInteriorTablePage(page *Page, bytes []byte, pageNum int, header *Header) *Page {
+func parseInteriorTablePage(page *Page, bytes []byte, pageNum int, header *Header, diag *diagnostics) (*Page, error) {
 	/*
 		Table B-Tree Interior Cell (header 0x05):
 			* A 4-byte big-endian page number which is the left child pointer.
 			* A varint which is the integer key
 	*/
-	//pageEnd := pageSize * pageNum
 
-	pageSize := header.pageSize
-	pageOffset := pageSize * (pageNum - 1)
-	for _, cellPtr := range page.cellPtrs {
-		cellOffset := cellPtr + pageOffset
-
-		childPageNumber := toInt(fetch(bytes, cellOffset, 4))
-		cellOffset += 4
-		rowid, n := decodeVarint(fetch(bytes, cellOffset, 8))
-
-		debug("rowid, childPageNumber:", rowid, childPageNumber, fetch(bytes, cellOffset-10, 8+10), cellOffset)
-		cellOffset += int(n)
+	for i := range page.cellPtrs {
+		c, err := parseCell(bytes, page, header, i)
+		if err != nil {
+			if diag.skip(err) {
+				continue
+			}
+			return nil, err
+		}
+		debug("rowid, childPageNumber:", c.rowid, c.child, c.offset)
 
 		page.rows = append(page.rows, &Row{
-			rowid: rowid,
-			//datas: []*Data{},
-			childPageNumber: childPageNumber,
+			rowid:           c.rowid,
+			childPageNumber: c.child,
 		})
 	}
 
-	return page
+	return page, nil
 }
 
-func parseLeafTablePage(page *Page, bytes []byte, pageNum int, header *Header) (*Page, error) {
+func parseLeafTablePage(page *Page, bytes []byte, pageNum int, header *Header, diag *diagnostics) (*Page, error) {
 	// In case of type=13 ...
 	/*
 		Table B-Tree Leaf Cell (header 0x0d):
@@ -250,103 +192,23 @@ func parseLeafTablePage(page *Page, bytes []byte, pageNum int, header *Header) (
 			the overflow page list - omitted if all payload fits on the b-tree page.
 	*/
 
-	pageSize := header.pageSize
-	pageOffset := pageSize * (pageNum - 1)
-	for _, cellPtr := range page.cellPtrs {
-		cellOffset := cellPtr + pageOffset
-
-		var v uint64
-		var i uint
-		delta := 0
-		payloadSize := 0
-
-		v, i = decodeVarint32(fetch(bytes, cellOffset, 8))
-		delta += int(i)
-		payloadSize = int(v)
-		//debug("payload size:", payloadSize, i, fetch(bytes, cellOffset, payloadSize+4))
-		debug("payld:", payloadSize, i, fetch(bytes, cellOffset, 8))
-
-		v, i = decodeVarint(fetch(bytes, cellOffset+delta, 8))
-		rowid := v
-		//debug("rowid:", rowid, i, cellOffset, delta, fetch(bytes, cellOffset+delta, 8), len(bytes))
-		delta += int(i)
-
-		// overflow
-		//if cellOffset+delta+payloadSize > pageNum*pageSize {
-		payloadBytes := []byte{}
-
-		if payloadSize > page.maxLocal {
-			//firstSize := pageNum*pageSize - (cellOffset + delta) - 4
-			nLocal := page.minLocal + (payloadSize-page.minLocal)%(header.usableSize-4)
-			if nLocal > page.maxLocal {
-				nLocal = header.minLocal
-			}
-
-			page.isOverflow = true
-
-			payloadBytes = fetchCopy(bytes, cellOffset+delta, nLocal)
-
-			overflow := fetchInt(bytes, cellOffset+delta+nLocal, 4)
-			for overflow > 0 {
-				nPage := payloadSize - nLocal
-				if nPage > header.usableSize {
-					nPage = header.usableSize
-				}
-				payloadBytes = append(payloadBytes, fetchCopy(bytes, (overflow-1)*pageSize+4, nPage)...)
-				overflow = fetchInt(bytes, (overflow-1)*pageSize, 4)
-				nLocal += nPage
-			}
-
-		} else {
-
-			//payloadBytes := fetch(bytes, cellOffset+delta, payloadSize)
-			payloadBytes = fetch(bytes, cellOffset+delta, payloadSize)
-		}
-
-		v, i = decodeVarint(payloadBytes)
-		headerSize := int(v)
-
-		//headerInts := []uint64{}
-		total := int(i)
-
-		dataShift := headerSize
-		row := &Row{rowid: rowid, datas: []*Data{}}
-		//debug("total vs headerSize", total, headerSize)
-		for total < headerSize {
-			v, i = decodeVarint(payloadBytes[total:])
-			if i == 0 {
-				return nil, errors.New("internal error")
-			}
-			total += int(i)
-
-			//headerInts = append(headerInts, v)
-
-			serialType := int(v)
-
-			if len(payloadBytes) < dataShift {
-				warn("[", page.pageNum, "]", "dataShift too large", len(payloadBytes), dataShift)
-				//debugPp(page)
-				//return page // TODO fix
-			}
-			d, err := takeData(fetch(payloadBytes, dataShift, 0), serialType)
-			if err != nil {
-				warn(err)
-				//return page // TODO fix
+	for i := range page.cellPtrs {
+		c, r, err := parseRecordCell(page, bytes, header, i)
+		if err != nil {
+			if diag.skip(err) {
+				continue
 			}
-
-			row.datas = append(row.datas, d)
-			dataShift += len(d.Bytes)
+			return nil, err
 		}
+		debug("payld:", c.payloadSize, "rowid:", c.rowid)
 
-		page.rows = append(page.rows, row)
-		//debug("offset:", payloadSize, delta)
-		cellOffset += payloadSize + delta
+		page.rows = append(page.rows, &Row{rowid: c.rowid, record: r})
 	}
 
 	return page, nil
 }
 
-func parsePage(cnt []byte, pageNum int, header *Header) *Page {
+func parsePage(cnt []byte, pageNum int, header *Header, diag *diagnostics) (*Page, error) {
 	page := &Page{
 		pageNum:  pageNum,
 		children: make(map[int]*Page),
@@ -365,6 +227,11 @@ func parsePage(cnt []byte, pageNum int, header *Header) *Page {
 	*/
 
 	page.pageType = toInt(fetch(cnt, offset, 1))
+	if page.pageType != interiorIndex && page.pageType != interiorTable &&
+		page.pageType != leafIndex && page.pageType != leafTable {
+		// overflow, freelist and pointer-map pages have no b-tree header
+		return &Page{pageNum: pageNum, children: page.children}, nil
+	}
 	if page.pageType == interiorTable || page.pageType == leafTable {
 		page.maxLocal = header.maxLeaf
 		page.minLocal = header.minLeaf
@@ -387,12 +254,6 @@ func parsePage(cnt []byte, pageNum int, header *Header) *Page {
 		cellPtrOffset = offset + 12
 	}
 
-	// empty page
-	if page.pageType == 0 {
-		//warn("empty page\n", pageNum)
-		return page
-	}
-
 	/*
 		A b-tree page is divided into regions in the following order:
 
@@ -404,6 +265,11 @@ func parsePage(cnt []byte, pageNum int, header *Header) *Page {
 			6. The reserved region.
 	*/
 
+	pageEnd := header.pageSize * pageNum
+	if cellPtrOffset+2*page.cellCount > pageEnd {
+		return nil, corruptf(pageNum, -1, offset+3, "%d cell pointers do not fit in the page", page.cellCount)
+	}
+
 	page.cellPtrs = []int{}
 	for i := 0; i < page.cellCount; i++ {
 		page.cellPtrs = append(page.cellPtrs, toInt(fetch(cnt, cellPtrOffset+2*i, 2)))
@@ -412,18 +278,22 @@ func parsePage(cnt []byte, pageNum int, header *Header) *Page {
 	// bytes: content witout free blocks
 	bytes := cnt
 
+	var err error
 	if page.pageType == interiorTable {
-		parseInteriorTablePage(page, bytes, pageNum, header)
+		_, err = parseInteriorTablePage(page, bytes, pageNum, header, diag)
 	} else if page.pageType == leafTable {
-		parseLeafTablePage(page, bytes, pageNum, header)
+		_, err = parseLeafTablePage(page, bytes, pageNum, header, diag)
 	} else if page.pageType == interiorIndex {
-		parseInteriorIndexPage(page, bytes, pageNum, header)
+		_, err = parseInteriorIndexPage(page, bytes, pageNum, header, diag)
 	} else if page.pageType == leafIndex {
-		parseLeafIndexPage(page, bytes, pageNum, header)
+		_, err = parseLeafIndexPage(page, bytes, pageNum, header, diag)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	//debugPp(page)
-	return page
+	return page, nil
 }
 
 // Page ...
@@ -447,22 +317,23 @@ type Page struct {
 	minLocal   int
 }
 
-func (page *Page) selectFirstChild(pages []*Page) *Page {
-	number := page.rows[0].childPageNumber
-	if number <= 0 {
-		number = page.rightPtr
+func (page *Page) selectFirstChild(pages []*Page) (*Page, error) {
+	number := page.rightPtr
+	if len(page.rows) > 0 {
+		number = page.rows[0].childPageNumber
 	}
-	if number <= 0 {
-		panic("selectFirstChild: no child")
+	if number <= 0 || number > len(pages) {
+		return nil, corruptf(page.pageNum, -1, -1, "no child page")
 	}
-	return pages[number-1]
+	return pages[number-1], nil
 }
 
 // Row ...
 type Row struct {
 	rowid uint64
 
-	datas []*Data // in a leaf table
+	datas  []*Data // in an index
+	record *record // in a leaf table
 
 	childPageNumber int // 4-byte integer in an interior table
 }
@@ -501,6 +372,9 @@ func takeData(bytes []byte, serialType int) (*Data, error) {
 		return nil, errors.New("Unkown serialType")
 	}
 
+	if size < 0 {
+		return nil, fmt.Errorf("Invalid serialType %d", serialType)
+	}
 	if len(bytes) < size {
 		return nil, fmt.Errorf("No enough bytes! [%d < %d]", len(bytes), size)
 	}
@@ -512,10 +386,10 @@ func takeData(bytes []byte, serialType int) (*Data, error) {
 	} else if or(serialType, []int{1, 2, 3, 4, 5, 6}) {
 		//value = strconv.Itoa(binary.BigEndian.Uint64(bs))
 		//value = strconv.FormatUint(binary.BigEndian.Uint64(bs), 10)
-		value = strconv.Itoa(toInt(bs))
+		value = strconv.FormatInt((&Data{SerialType: serialType, Bytes: bs}).int64(), 10)
 	} else if serialType == 7 {
 		f := math.Float64frombits(binary.BigEndian.Uint64(bs))
-		value = strconv.FormatFloat(f, 'e', 8, 64)
+		value = FormatReal(f, RealSQLite)
 	} else if serialType == 8 {
 		value = "0"
 	} else if serialType == 9 {
@@ -597,7 +471,11 @@ type Header struct {
 	minLeaf    int
 }
 
-func parseHeader(bytes []byte) *Header {
+func parseHeader(bytes []byte) (*Header, error) {
+	if len(bytes) < 100 || string(bytes[0:16]) != "SQLite format 3\x00" {
+		return nil, ErrNotSQLite
+	}
+
 	header := &Header{
 		headerString:   string(bytes[0:16]),
 		pageSize:       fetchInt(bytes, 16, 2),
@@ -624,82 +502,203 @@ func parseHeader(bytes []byte) *Header {
 		sqlNum:         fetchInt(bytes, 96, 4),
 	}
 
+	if header.pageSize == 1 {
+		header.pageSize = 65536
+	}
+	if header.pageSize < 512 || header.pageSize&(header.pageSize-1) != 0 {
+		return nil, corruptf(1, -1, 16, "invalid page size %d", header.pageSize)
+	}
+	if header.readVersion > 2 {
+		return nil, unsupportedf("file format read version %d", header.readVersion)
+	}
+
 	usableSize := header.pageSize - header.reservedSize
+	if usableSize < 480 {
+		return nil, corruptf(1, -1, 20, "%d reserved bytes leave %d usable", header.reservedSize, usableSize)
+	}
 	header.usableSize = usableSize
 	header.maxLocal = (usableSize-12)*64/255 - 23
 	header.minLocal = (usableSize-12)*32/255 - 23
 	header.maxLeaf = usableSize - 35
 	header.minLeaf = (usableSize-12)*32/255 - 23
 
-	return header
+	return header, nil
 }
 
-// Storage ...
+// WALMode reports whether the database is in WAL mode, as the read and
+// write format versions 2 say.
+func (h *Header) WALMode() bool {
+	return h.readVersion == 2 && h.writeVersion == 2
+}
+
+// HeaderField is a decoded field of the database header.
+type HeaderField struct {
+	Offset int
+	Name   string
+	Value  int
+}
+
+// Fields lists the fields of the header in file order, named like the
+// .dbinfo command of sqlite3.
+func (h *Header) Fields() []*HeaderField {
+	return []*HeaderField{
+		{16, "database page size", h.pageSize},
+		{18, "write format", h.writeVersion},
+		{19, "read format", h.readVersion},
+		{20, "reserved bytes", h.reservedSize},
+		{24, "file change counter", h.changeCounter},
+		{28, "database page count", h.inHeaderDbSize},
+		{32, "freelist trunk page", h.freeTrunk1st},
+		{36, "freelist page count", h.totalFree},
+		{40, "schema cookie", h.schemaCookie},
+		{44, "schema format", h.schemaNumber},
+		{48, "default cache size", h.cacheSize},
+		{52, "autovacuum top root", h.logest},
+		{56, "text encoding", h.encoding},
+		{60, "user version", h.userVersion},
+		{64, "incremental vacuum", h.vacuumMode},
+		{68, "application id", h.appID},
+		{92, "version-valid-for", h.vvfNum},
+		{96, "software version", h.sqlNum},
+	}
+}
+
+// Storage is a parsed database. Once loaded it is only read, so its
+// methods and the functions taking it are safe for concurrent use by
+// multiple goroutines, as long as none of them modifies its exported
+// fields, the rows included.
 type Storage struct {
 	Path string
 
-	Header *Header
-	Pages  []*Page
-	Tables map[string]*Table
+	Header  *Header
+	WAL     *WAL
+	Pages   []*Page
+	Tables  map[string]*Table
+	Schemas []*Schema
+
+	// Diagnostics lists the corruption skipped in Recover mode.
+	Diagnostics []*CorruptError
+
+	cnt        []byte   // database image the pages were parsed from
+	owners     []string // see pageOwners
+	ownersOnce sync.Once
+}
+
+// Schema returns the sqlite_master entry of the named object, or nil.
+func (s *Storage) Schema(name string) *Schema {
+	for _, schema := range s.Schemas {
+		if strings.EqualFold(schema.Name, name) {
+			return schema
+		}
+	}
+	return nil
 }
 
 // Entry ...
 type Entry struct {
-	Datas []*Data
+	Rowid uint64  // 0 for the rows of a WITHOUT ROWID table
+	Datas []*Data // nil for the rows loaded with LoadOptions.Lazy
+
+	record  *record // until the fields are decoded
+	present *presentation
 }
 
 // Table ...
 type Table struct {
 	Entries []*Entry
+
+	storage *Storage // see Count
+	root    int
+	present *presentation
 }
 
-func makeTable(pages []*Page) *Table {
-	table := &Table{}
+// makeTable makes the rows of the leaf pages without decoding them.
+func makeTable(pages []*Page, header *Header) *Table {
+	table := &Table{present: &presentation{header: header}}
 
 	for _, p := range pages {
 		for _, i := range p.rows {
-			table.Entries = append(table.Entries, &Entry{i.datas})
+			table.Entries = append(table.Entries, &Entry{Rowid: i.rowid, record: i.record, present: table.present})
 		}
 	}
 
 	return table
 }
 
-func fillChildren(pages []*Page) {
+func fillChildren(pages []*Page, diag *diagnostics) error {
+	child := func(page *Page, number int) error {
+		if number < 1 || number > len(pages) || number == page.pageNum {
+			err := corruptf(page.pageNum, -1, -1, "child page %d out of range", number)
+			if diag.skip(err) {
+				return nil
+			}
+			return err
+		}
+		page.children[number] = pages[number-1]
+		return nil
+	}
+
 	for _, page := range pages {
 		if page.rightPtr != 0 {
-			page.children[page.rightPtr] = pages[page.rightPtr-1]
+			if err := child(page, page.rightPtr); err != nil {
+				return err
+			}
 		}
 
-		if page.pageType == interiorTable {
+		if page.pageType == interiorTable || page.pageType == interiorIndex {
 			for _, r := range page.rows {
-				number := r.childPageNumber
-				page.children[number] = pages[number-1]
+				if err := child(page, r.childPageNumber); err != nil {
+					return err
+				}
 			}
 		}
 	}
+	return nil
 }
 
-func selectFirstLeafTable(pages ...*Page) *Page {
+func selectFirstLeafTable(pages ...*Page) (*Page, error) {
 	page := pages[0]
-	for page.pageType != leafTable {
-		page = page.selectFirstChild(pages)
+	for depth := 0; page.pageType != leafTable; depth++ {
+		if page.pageType != interiorTable || depth > len(pages) {
+			return nil, corruptf(page.pageNum, -1, -1, "no leaf table page")
+		}
+		var err error
+		page, err = page.selectFirstChild(pages)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return page
+	return page, nil
 }
 
 func walkPage(page *Page, pageType int) []*Page {
+	return walkPageOnce(page, pageType, map[int]bool{})
+}
+
+// walkPageOnce visits the children in key order and each page at most
+// once, so that a corrupt tree with cycles does not recurse forever.
+func walkPageOnce(page *Page, pageType int, visited map[int]bool) []*Page {
 	ret := []*Page{}
+	if visited[page.pageNum] {
+		return ret
+	}
+	visited[page.pageNum] = true
+
 	if page.pageType == pageType {
 		ret = append(ret, page)
 	}
-	for _, p := range page.children {
-		ret = append(ret, walkPage(p, pageType)...)
+	for _, r := range page.rows {
+		if p, ok := page.children[r.childPageNumber]; ok {
+			ret = append(ret, walkPageOnce(p, pageType, visited)...)
+		}
+	}
+	if p, ok := page.children[page.rightPtr]; ok {
+		ret = append(ret, walkPageOnce(p, pageType, visited)...)
 	}
 	return ret
 }
 
-func makeTables(pages []*Page) (map[string]*Table, error) {
+func makeTables(pages []*Page, header *Header, diag *diagnostics) (map[string]*Table, error) {
 	m := map[string]*Table{}
 
 	// CREATE TABLE sqlite_master ( type text, name text, tbl_name text, rootpage integer, sql text);
@@ -707,55 +706,96 @@ func makeTables(pages []*Page) (map[string]*Table, error) {
 	masterPages := []*Page{}
 
 	firstPageType := pages[0].pageType
-	if firstPageType == leafTable {
-		masterPages = append(masterPages, pages[0])
-	} else if firstPageType == interiorTable {
-		for _, page := range pages[0].children {
-			masterPages = append(masterPages, page)
-		}
+	if firstPageType == leafTable || firstPageType == interiorTable {
+		masterPages = walkPage(pages[0], leafTable)
 	} else {
-		panic("!!!")
+		err := corruptf(1, -1, 100, "sqlite_master has page type %d", firstPageType)
+		if !diag.skip(err) {
+			return nil, err
+		}
 	}
 
-	m["sqlite_master"] = makeTable(masterPages)
+	m["sqlite_master"] = makeTable(masterPages, header)
+	m["sqlite_master"].root = 1
+	m["sqlite_master"].decode()
 	//pp.Println(m["sqlite_master"])
 
-	for _, v := range m["sqlite_master"].Entries {
-		tableName := v.Datas[2].Value
+	for i, v := range m["sqlite_master"].Entries {
+		if len(v.Datas) < 5 {
+			err := corruptf(1, -1, -1, "sqlite_master row %d has %d columns", i, len(v.Datas))
+			if diag.skip(err) {
+				continue
+			}
+			return nil, err
+		}
+		tableName := masterText(v.Datas[2], header.encoding)
 		rootPageNum, err := strconv.Atoi(v.Datas[3].Value)
 		if err != nil {
+			err := corruptf(1, -1, -1, "sqlite_master row %d has root page %q", i, v.Datas[3].Value)
+			if diag.skip(err) {
+				continue
+			}
 			return nil, err
 		}
 
 		if rootPageNum == 0 {
 			continue
 		}
+		if rootPageNum < 0 || rootPageNum > len(pages) {
+			err := corruptf(1, -1, -1, "root page %d of %s out of range", rootPageNum, tableName)
+			if diag.skip(err) {
+				continue
+			}
+			return nil, err
+		}
 		rootPage := pages[rootPageNum-1]
 		if rootPage.pageType == interiorIndex || rootPage.pageType == leafIndex {
 			continue
 		}
 
-		m[tableName] = makeTable(walkPage(rootPage, leafTable))
+		m[tableName] = makeTable(walkPage(rootPage, leafTable), header)
+		m[tableName].root = rootPageNum
 	}
 
 	return m, nil
 }
 
-// Load ...
-func Load(path string) (*Storage, error) {
+// readDatabase reads the database file at path and its WAL file, if any.
+func readDatabase(path string) ([]byte, *WAL, error) {
 
 	file, err := os.Open(path)
 	defer file.Close()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	cnt, err := ioutil.ReadAll(file)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	wal, err := loadWAL(path + "-wal")
+	if err != nil {
+		return nil, nil, err
 	}
 
-	header := parseHeader(cnt)
+	return cnt, wal, nil
+}
+
+// Load ...
+func Load(path string) (*Storage, error) {
+	return LoadWithOptions(path, LoadOptions{})
+}
+
+// parseStorage parses the database image cnt. Corruption is skipped and
+// recorded in diag instead of failing when diag is not nil. The pages
+// are parsed by workers goroutines, or a b-tree per goroutine with
+// trees, see parsePages.
+func parseStorage(path string, cnt []byte, wal *WAL, diag *diagnostics, workers int, trees bool) (*Storage, error) {
+	header, err := parseHeader(cnt)
+	if err != nil {
+		return nil, err
+	}
 
 	/*
 		// lock-byte  1073741823:1073742336
@@ -768,32 +808,222 @@ func Load(path string) (*Storage, error) {
 
 	//schemaPage := parsePage(cnt, 1, header.pageSize)
 
-	pages := []*Page{}
-	pageNo := 0
-	freeCount := 0
-	for header.pageSize*pageNo < len(cnt) {
-		pageNo++
-		page := parsePage(cnt, pageNo, header)
-		pages = append(pages, page)
+	unused, err := unusedPages(cnt, header)
+	if diag.skip(err) {
+		unused = map[int]bool{}
+	} else if err != nil {
+		return nil, err
+	}
 
-		if page.pageType == 0 {
-			freeCount++
-			//pp.Println(page)
-		}
+	pages, err := parsePages(cnt, header, unused, diag, workers, trees)
+	if err != nil {
+		return nil, err
+	}
 
+	if len(pages) == 0 {
+		return nil, corruptf(1, -1, -1, "file of %d bytes holds no %d-byte page", len(cnt), header.pageSize)
 	}
 
-	fillChildren(pages)
+	if err := fillChildren(pages, diag); err != nil {
+		return nil, err
+	}
 
-	tables, err := makeTables(pages)
+	tables, err := makeTables(pages, header, diag)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Storage{
-		Path:   path,
-		Header: header,
-		Pages:  pages,
-		Tables: tables,
-	}, nil
+	storage := &Storage{
+		Path:    path,
+		Header:  header,
+		WAL:     wal,
+		Pages:   pages,
+		Tables:  tables,
+		Schemas: makeSchemas(tables["sqlite_master"], header.encoding),
+		cnt:     cnt,
+
+		Diagnostics: diag.list(),
+	}
+	for _, table := range tables {
+		table.storage = storage
+	}
+	storage.padRows()
+	return storage, nil
+}
+
+// parsePages parses every page of the image. A partial page at the end
+// of the file is ignored like SQLite does. With more than one worker,
+// each worker parses a contiguous range of pages; with trees, each
+// b-tree listed in sqlite_master is parsed in a goroutine of its own,
+// see parseTrees. Either way, the corruption skipped is recorded in page
+// order, as the sequential parse does.
+func parsePages(cnt []byte, header *Header, unused map[int]bool, diag *diagnostics, workers int, trees bool) ([]*Page, error) {
+	parse := func(pageNum int, diag *diagnostics) (*Page, error) {
+		if unused[pageNum] {
+			return &Page{pageNum: pageNum, children: make(map[int]*Page)}, nil
+		}
+		page, err := parsePage(cnt, pageNum, header, diag)
+		if diag.skip(err) {
+			page = &Page{pageNum: pageNum, children: make(map[int]*Page)}
+		} else if err != nil {
+			return nil, err
+		}
+		return page, nil
+	}
+
+	pages := make([]*Page, len(cnt)/header.pageSize)
+	if !trees && (workers <= 1 || len(pages) < 2*workers) {
+		for i := range pages {
+			page, err := parse(i+1, diag)
+			if err != nil {
+				return nil, err
+			}
+			pages[i] = page
+		}
+		return pages, nil
+	}
+
+	errs := make([]error, len(pages))
+	diags := make([]*diagnostics, len(pages))
+	parseAt := func(i int) {
+		if diag != nil {
+			diags[i] = &diagnostics{}
+		}
+		pages[i], errs[i] = parse(i+1, diags[i])
+	}
+
+	if trees {
+		parseTrees(cnt, header, pages, parseAt)
+	} else {
+		chunk := (len(pages) + workers - 1) / workers
+		var wg sync.WaitGroup
+		for start := 0; start < len(pages); start += chunk {
+			end := start + chunk
+			if end > len(pages) {
+				end = len(pages)
+			}
+			wg.Add(1)
+			go func(start, end int) {
+				defer wg.Done()
+				for i := start; i < end; i++ {
+					parseAt(i)
+				}
+			}(start, end)
+		}
+		wg.Wait()
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+		if diag != nil {
+			diag.errs = append(diag.errs, diags[i].errs...)
+		}
+	}
+	return pages, nil
+}
+
+// parseTrees parses sqlite_master and every b-tree it lists, following
+// child pointers from the root, a tree per goroutine and up to
+// GOMAXPROCS at a time. The root pages are read from sqlite_master
+// before any page is parsed. Pages no tree reaches, like overflow and
+// freelist pages, are parsed afterwards, and so are all of them if
+// sqlite_master cannot be read.
+func parseTrees(cnt []byte, header *Header, pages []*Page, parseAt func(int)) {
+	roots := []int{1}
+	if schemas, err := readSchemas(imagePage(cnt, header), header); err == nil {
+		for _, schema := range schemas {
+			if schema.RootPage > 1 {
+				roots = append(roots, schema.RootPage)
+			}
+		}
+	}
+
+	// a page of a corrupt file may be reached from more than one tree
+	claimed := make([]int32, len(pages))
+	var walk func(pageNum int)
+	walk = func(pageNum int) {
+		if pageNum < 1 || pageNum > len(pages) || !atomic.CompareAndSwapInt32(&claimed[pageNum-1], 0, 1) {
+			return
+		}
+		parseAt(pageNum - 1)
+		page := pages[pageNum-1]
+		if page == nil || page.pageType != interiorTable && page.pageType != interiorIndex {
+			return
+		}
+		for _, r := range page.rows {
+			walk(r.childPageNumber)
+		}
+		walk(page.rightPtr)
+	}
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for root := range queue {
+				walk(root)
+			}
+		}()
+	}
+	for _, root := range roots {
+		queue <- root
+	}
+	close(queue)
+	wg.Wait()
+
+	for i := range pages {
+		if claimed[i] == 0 {
+			parseAt(i)
+		}
+	}
+}
+
+// padRows gives every row of a table as many fields as the table has
+// columns. Rows written before ALTER TABLE ADD COLUMN lack the trailing
+// columns, which read as their DEFAULT value.
+func (s *Storage) padRows() {
+	for _, schema := range s.Schemas {
+		table, ok := s.Tables[schema.Name]
+		if schema.Type != "table" || !ok || schema.WithoutRowid {
+			continue
+		}
+		defaults := make([]*Data, len(schema.Columns))
+		for i, column := range schema.Columns {
+			v := applyAffinity(column.defaultValue(), column.Affinity())
+			defaults[i] = valueData(v, s.Header)
+		}
+		table.present.defaults = defaults
+	}
+}
+
+// decodeRows decodes the fields of every row not decoded yet, the
+// tables in parallel if parallel is set.
+func (s *Storage) decodeRows(parallel bool) {
+	if !parallel {
+		for _, table := range s.Tables {
+			table.decode()
+		}
+		return
+	}
+
+	tables := make(chan *Table)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for table := range tables {
+				table.decode()
+			}
+		}()
+	}
+	for _, table := range s.Tables {
+		tables <- table
+	}
+	close(tables)
+	wg.Wait()
 }
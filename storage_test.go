@@ -60,6 +60,52 @@ func TestSimpleLoad(t *testing.T) {
 	rmSQLite(filename)
 }
 
+func TestNegativeIntegers(t *testing.T) {
+	filename := "/tmp/test_negative.db"
+	rmSQLite(filename)
+
+	// an integer of each size, from 1 to 8 bytes
+	execSQLite(filename, []string{
+		"CREATE TABLE n(v integer);",
+		"INSERT INTO n VALUES (-1), (-129), (-32769), (-8388609), (-2147483649), (-140737488355329), (-9223372036854775808), (-0);",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	values := []string{}
+	for _, e := range storage.Tables["n"].Entries {
+		values = append(values, e.Datas[0].Value)
+	}
+	assert.Equal(t, []string{"-1", "-129", "-32769", "-8388609", "-2147483649", "-140737488355329", "-9223372036854775808", "0"}, values)
+
+	rmSQLite(filename)
+}
+
+func TestAddColumnDefaults(t *testing.T) {
+	filename := "/tmp/test_add_column.db"
+	rmSQLite(filename)
+
+	execSQLite(filename, []string{
+		"CREATE TABLE person(id integer, name text);",
+		"INSERT INTO person VALUES (1, \"hoge\");",
+		"ALTER TABLE person ADD COLUMN hp integer DEFAULT 5; ALTER TABLE person ADD COLUMN memo text;",
+		"ALTER TABLE person ADD COLUMN score real DEFAULT (-2);",
+		"INSERT INTO person VALUES (2, \"foo\", 10, \"x\", 1.5);",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	entries := storage.Tables["person"].Entries
+	assert.Equal(t, 5, len(entries[0].Datas))
+	assert.Equal(t, "5", entries[0].Datas[2].Value)
+	assert.True(t, entries[0].Datas[3].isNull())
+	assert.Equal(t, -2.0, entries[0].Datas[4].float64())
+	assert.True(t, entries[0].Datas[4].isFloat())
+	assert.Equal(t, "10", entries[1].Datas[2].Value)
+
+	rmSQLite(filename)
+}
+
 /*
 func TestSvn(t *testing.T) {
 	//filename := "/home/vagrant/simple.wc.db"
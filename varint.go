@@ -27,56 +27,32 @@ func toUint64(v uint64) []byte {
 }
 
 func decodeVarint32(bytes []byte) (uint64, uint) {
-	v := uint64(bytes[0])
-	consume := uint(1)
-	consumeMax := uint(8)
-	if v >= 0x80 {
-		v &= 0x7f
-
-		for {
-			a := uint64(bytes[consume])
-			v = (v << 7) | (a & 0x7f)
-			consume++
-
-			if !(a >= 0x80 && consume < consumeMax) {
-				break
-			}
-		}
-	}
-	return v, consume
-
+	return decodeVarint(bytes)
 }
 
 // sqlite3/src/util.c:825
+//
+// decodeVarint returns the value and the number of bytes read. The
+// number of bytes is 0 when bytes ends in the middle of the varint.
 func decodeVarint(bytes []byte) (uint64, uint) {
-	v := uint64(bytes[0])
-	consume := uint(1)
-	consumeMax := uint(8)
-	//fmt.Println("varint>>", v, consume)
-
-	if v >= 0x80 {
-		v &= 0x7f
-		//fmt.Println("varint>>", v, consume)
-
-		for {
-			a := uint64(bytes[consume])
-			v = (v << 7) | (a & 0x7f)
-			//fmt.Println("varint>>", v, consume, a)
-			consume++
-
-			if a < 0x80 {
-				break
-			}
-			if consume >= consumeMax-1 {
-				a := uint64(bytes[consume])
-				v = (v << 8) | a
-				//fmt.Println("varint>>", v, consume, a)
-				consume++
-				break
-			}
+	v := uint64(0)
+	for i := 0; i < 9; i++ {
+		if i >= len(bytes) {
+			return 0, 0
+		}
+		a := uint64(bytes[i])
+
+		// the ninth byte contributes all of its 8 bits
+		if i == 8 {
+			return (v << 8) | a, 9
+		}
+
+		v = (v << 7) | (a & 0x7f)
+		if a < 0x80 {
+			return v, uint(i + 1)
 		}
 	}
-	return v, consume
+	return v, 9
 }
 
 // sqlite3/src/util.c:759
@@ -0,0 +1,248 @@
+package sqlite3utils
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+)
+
+/***********************************************************
+
+Write-Ahead Log (<db>-wal)
+
+WAL header (32 bytes):
+	0  magic number 0x377f0682 or 0x377f0683
+	4  file format version (3007000)
+	8  database page size
+	12 checkpoint sequence number
+	16 salt-1
+	20 salt-2
+	24 checksum-1
+	28 checksum-2
+
+Frame header (24 bytes), followed by a page image:
+	0  page number
+	4  database size in pages after a commit, 0 otherwise
+	8  salt-1 copied from the WAL header
+	12 salt-2 copied from the WAL header
+	16 checksum-1
+	20 checksum-2
+
+***********************************************************/
+
+const (
+	walMagic           = 0x377f0682
+	walVersion         = 3007000
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+)
+
+// WALHeader ...
+type WALHeader struct {
+	magic         uint32
+	version       int
+	pageSize      int
+	checkpointSeq uint32
+	salt1         uint32
+	salt2         uint32
+	checksum1     uint32
+	checksum2     uint32
+}
+
+// bigEndian reports whether the checksums are computed on big-endian words.
+func (h *WALHeader) bigEndian() bool {
+	return h.magic&1 == 1
+}
+
+// bytes encodes the header, recomputing its checksum.
+func (h *WALHeader) bytes() []byte {
+	ret := make([]byte, walHeaderSize)
+	binary.BigEndian.PutUint32(ret[0:], h.magic)
+	binary.BigEndian.PutUint32(ret[4:], uint32(h.version))
+	binary.BigEndian.PutUint32(ret[8:], uint32(h.pageSize&0xffff|h.pageSize>>16))
+	binary.BigEndian.PutUint32(ret[12:], h.checkpointSeq)
+	binary.BigEndian.PutUint32(ret[16:], h.salt1)
+	binary.BigEndian.PutUint32(ret[20:], h.salt2)
+	h.checksum1, h.checksum2 = walChecksum(h.bigEndian(), ret[0:24], 0, 0)
+	binary.BigEndian.PutUint32(ret[24:], h.checksum1)
+	binary.BigEndian.PutUint32(ret[28:], h.checksum2)
+	return ret
+}
+
+// WALFrame ...
+type WALFrame struct {
+	pageNum   int
+	dbSize    int // nonzero for a commit frame
+	salt1     uint32
+	salt2     uint32
+	checksum1 uint32
+	checksum2 uint32
+
+	offset int // offset of the frame header in the WAL file
+	data   []byte
+}
+
+func (f *WALFrame) isCommit() bool {
+	return f.dbSize > 0
+}
+
+// WAL ...
+type WAL struct {
+	Path string
+
+	Header *WALHeader
+	Frames []*WALFrame // frames whose salts and checksums are valid
+
+	// mxFrame is the number of frames up to and including the last
+	// valid commit frame. Frames after it belong to an unfinished
+	// transaction and must be ignored.
+	mxFrame int
+}
+
+// sqlite3/src/wal.c:walChecksumBytes
+func walChecksum(bigEndian bool, bytes []byte, s1, s2 uint32) (uint32, uint32) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	for i := 0; i+8 <= len(bytes); i += 8 {
+		s1 += order.Uint32(bytes[i:]) + s2
+		s2 += order.Uint32(bytes[i+4:]) + s1
+	}
+	return s1, s2
+}
+
+func parseWALHeader(bytes []byte) *WALHeader {
+	if len(bytes) < walHeaderSize {
+		return nil
+	}
+	header := &WALHeader{
+		magic:         binary.BigEndian.Uint32(bytes[0:]),
+		version:       fetchInt(bytes, 4, 4),
+		pageSize:      fetchInt(bytes, 8, 4),
+		checkpointSeq: binary.BigEndian.Uint32(bytes[12:]),
+		salt1:         binary.BigEndian.Uint32(bytes[16:]),
+		salt2:         binary.BigEndian.Uint32(bytes[20:]),
+		checksum1:     binary.BigEndian.Uint32(bytes[24:]),
+		checksum2:     binary.BigEndian.Uint32(bytes[28:]),
+	}
+	if header.magic&^1 != walMagic || header.version != walVersion {
+		return nil
+	}
+	if header.pageSize == 1 {
+		header.pageSize = 65536
+	}
+	if header.pageSize < 512 || header.pageSize > 65536 || header.pageSize&(header.pageSize-1) != 0 {
+		return nil
+	}
+
+	s1, s2 := walChecksum(header.bigEndian(), bytes[0:24], 0, 0)
+	if s1 != header.checksum1 || s2 != header.checksum2 {
+		return nil
+	}
+	return header
+}
+
+// parseWAL reads the WAL header and every frame that continues the
+// checksum chain. A WAL with a broken header is treated as empty, the
+// same as SQLite does.
+func parseWAL(bytes []byte) *WAL {
+	wal := &WAL{Frames: []*WALFrame{}}
+
+	header := parseWALHeader(bytes)
+	if header == nil {
+		return wal
+	}
+	wal.Header = header
+
+	frameSize := walFrameHeaderSize + header.pageSize
+	s1, s2 := header.checksum1, header.checksum2
+	for offset := walHeaderSize; offset+frameSize <= len(bytes); offset += frameSize {
+		frame := &WALFrame{
+			pageNum:   fetchInt(bytes, offset, 4),
+			dbSize:    fetchInt(bytes, offset+4, 4),
+			salt1:     binary.BigEndian.Uint32(bytes[offset+8:]),
+			salt2:     binary.BigEndian.Uint32(bytes[offset+12:]),
+			checksum1: binary.BigEndian.Uint32(bytes[offset+16:]),
+			checksum2: binary.BigEndian.Uint32(bytes[offset+20:]),
+			offset:    offset,
+			data:      fetch(bytes, offset+walFrameHeaderSize, header.pageSize),
+		}
+
+		if frame.pageNum == 0 || frame.salt1 != header.salt1 || frame.salt2 != header.salt2 {
+			break
+		}
+		s1, s2 = walChecksum(header.bigEndian(), fetch(bytes, offset, 8), s1, s2)
+		s1, s2 = walChecksum(header.bigEndian(), frame.data, s1, s2)
+		if s1 != frame.checksum1 || s2 != frame.checksum2 {
+			break
+		}
+
+		wal.Frames = append(wal.Frames, frame)
+		if frame.isCommit() {
+			wal.mxFrame = len(wal.Frames)
+		}
+	}
+
+	return wal
+}
+
+// loadWAL reads the WAL file at path. It returns nil without an error
+// when the file does not exist.
+func loadWAL(path string) (*WAL, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cnt, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	wal := parseWAL(cnt)
+	wal.Path = path
+	return wal, nil
+}
+
+// latestFrames maps each page number to its last frame among the first
+// mxFrame frames.
+func (wal *WAL) latestFrames(mxFrame int) map[int]*WALFrame {
+	ret := map[int]*WALFrame{}
+	for _, frame := range wal.Frames[:mxFrame] {
+		ret[frame.pageNum] = frame
+	}
+	return ret
+}
+
+// overlay returns the database image as of the first mxFrame frames:
+// the latest committed image of each page replaces the one in cnt and
+// the file is resized to the database size of the last commit.
+func (wal *WAL) overlay(cnt []byte, mxFrame int) []byte {
+	if wal == nil || wal.Header == nil || mxFrame == 0 {
+		return cnt
+	}
+
+	pageSize := wal.Header.pageSize
+	dbSize := wal.Frames[mxFrame-1].dbSize
+
+	// every page past the end of the database file must have been
+	// written to the WAL, which bounds a corrupt database size
+	if limit := len(cnt)/pageSize + mxFrame + 1; dbSize > limit {
+		dbSize = limit
+	}
+
+	ret := make([]byte, pageSize*dbSize)
+	copy(ret, cnt)
+	for pageNum, frame := range wal.latestFrames(mxFrame) {
+		if pageNum > dbSize {
+			continue
+		}
+		copy(ret[pageSize*(pageNum-1):], frame.data)
+	}
+	return ret
+}
@@ -0,0 +1,64 @@
+package sqlite3utils
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// noCheckpoint keeps the sqlite3 shell from checkpointing and deleting
+// the WAL when it exits.
+const noCheckpoint = ".dbconfig no_ckpt_on_close on\n"
+
+func TestWALOverlay(t *testing.T) {
+	filename := "/tmp/test_wal.db"
+	rmSQLite(filename)
+	rmSQLite(filename + "-wal")
+	rmSQLite(filename + "-shm")
+
+	execSQLite(filename, []string{
+		"PRAGMA journal_mode=WAL;",
+		noCheckpoint + "CREATE TABLE person(id integer, name text);",
+		noCheckpoint + "INSERT INTO person VALUES (1, \"hoge\");",
+		noCheckpoint + "INSERT INTO person VALUES (2, \"foo\");",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	assert.NotNil(t, storage.WAL)
+	assert.Equal(t, 2, len(storage.Tables["person"].Entries))
+	assert.Equal(t, "hoge", storage.Tables["person"].Entries[0].Datas[1].Value)
+	assert.Equal(t, "foo", storage.Tables["person"].Entries[1].Datas[1].Value)
+
+	// Breaking the checksum of the last frame drops the last commit.
+	bytes, _ := ioutil.ReadFile(filename + "-wal")
+	bytes[len(bytes)-1] ^= 0xff
+	ioutil.WriteFile(filename+"-wal", bytes, 0644)
+
+	storage, err = Load(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(storage.Tables["person"].Entries))
+	assert.Equal(t, "hoge", storage.Tables["person"].Entries[0].Datas[1].Value)
+
+	rmSQLite(filename)
+	rmSQLite(filename + "-wal")
+	rmSQLite(filename + "-shm")
+}
+
+func TestWALChecksum(t *testing.T) {
+	s1, s2 := walChecksum(true, []byte{0, 0, 0, 1, 0, 0, 0, 2}, 0, 0)
+	assert.Equal(t, uint32(1), s1)
+	assert.Equal(t, uint32(3), s2)
+
+	s1, s2 = walChecksum(false, []byte{1, 0, 0, 0, 2, 0, 0, 0}, 0, 0)
+	assert.Equal(t, uint32(1), s1)
+	assert.Equal(t, uint32(3), s2)
+}
+
+func TestWALBrokenHeader(t *testing.T) {
+	wal := parseWAL([]byte("not a wal file"))
+	assert.Nil(t, wal.Header)
+	assert.Equal(t, 0, len(wal.Frames))
+	assert.Equal(t, []byte{1, 2}, wal.overlay([]byte{1, 2}, wal.mxFrame))
+}
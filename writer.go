@@ -0,0 +1,346 @@
+package sqlite3utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+/***********************************************************
+
+Rollback journal (<db>-journal)
+
+Header (padded to the sector size):
+	0  magic d9 d5 05 f9 20 a1 63 d7
+	8  number of page records
+	12 random nonce for the checksums
+	16 initial size of the database in pages
+	20 sector size
+	24 page size
+
+Page record:
+	0  page number
+	4  original page image
+	n  checksum: nonce plus every 200th byte of the page image
+
+***********************************************************/
+
+var journalMagic = []byte{0xd9, 0xd5, 0x05, 0xf9, 0x20, 0xa1, 0x63, 0xd7}
+
+const journalSectorSize = 512
+
+// WriterOptions ...
+type WriterOptions struct {
+	// WAL appends the modified pages as frames to <db>-wal instead of
+	// modifying the database file. The database must be in WAL mode.
+	WAL bool
+}
+
+// Writer modifies a database file page by page. Pages written with
+// WritePage are kept in memory until Commit, which either goes through a
+// rollback journal or appends to the WAL. No other process may have the
+// database open while a Writer is in use.
+type Writer struct {
+	Path string
+
+	options  WriterOptions
+	header   *Header
+	wal      *WAL
+	cnt      []byte // committed database image
+	dbSize   int
+	modified map[int][]byte
+}
+
+// OpenWriter ...
+func OpenWriter(path string, options WriterOptions) (*Writer, error) {
+	cnt, wal, err := readDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	if wal != nil {
+		cnt = wal.overlay(cnt, wal.mxFrame)
+	}
+	header, err := parseHeader(cnt)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.WAL && header.writeVersion != 2 {
+		return nil, errors.New("Database is not in WAL mode")
+	}
+	if !options.WAL && wal != nil && wal.mxFrame > 0 {
+		return nil, errors.New("WAL has frames to checkpoint")
+	}
+
+	return &Writer{
+		Path:     path,
+		options:  options,
+		header:   header,
+		wal:      wal,
+		cnt:      cnt,
+		dbSize:   len(cnt) / header.pageSize,
+		modified: map[int][]byte{},
+	}, nil
+}
+
+// PageSize ...
+func (w *Writer) PageSize() int {
+	return w.header.pageSize
+}
+
+// DbSize returns the database size in pages including pending changes.
+func (w *Writer) DbSize() int {
+	return w.dbSize
+}
+
+// Page returns a copy of the current image of the page, including
+// changes not yet committed.
+func (w *Writer) Page(pageNum int) ([]byte, error) {
+	if pageNum < 1 || pageNum > w.dbSize {
+		return nil, fmt.Errorf("Page %d out of range", pageNum)
+	}
+
+	ret := make([]byte, w.header.pageSize)
+	if data, ok := w.modified[pageNum]; ok {
+		copy(ret, data)
+	} else if w.header.pageSize*pageNum <= len(w.cnt) {
+		copy(ret, fetch(w.cnt, w.header.pageSize*(pageNum-1), w.header.pageSize))
+	}
+	return ret, nil
+}
+
+// WritePage replaces the image of the page. Writing the page right after
+// the last one grows the database.
+func (w *Writer) WritePage(pageNum int, data []byte) error {
+	if pageNum < 1 || pageNum > w.dbSize+1 {
+		return fmt.Errorf("Page %d out of range", pageNum)
+	}
+	if len(data) != w.header.pageSize {
+		return fmt.Errorf("Page image must be %d bytes, not %d", w.header.pageSize, len(data))
+	}
+
+	w.modified[pageNum] = fetchCopy(data, 0, len(data))
+	if pageNum > w.dbSize {
+		w.dbSize = pageNum
+	}
+	return nil
+}
+
+// Rollback discards the pending changes.
+func (w *Writer) Rollback() {
+	w.modified = map[int][]byte{}
+	w.dbSize = len(w.cnt) / w.header.pageSize
+}
+
+// Commit writes the pending changes as one transaction.
+func (w *Writer) Commit() error {
+	if len(w.modified) == 0 {
+		return nil
+	}
+
+	var err error
+	if w.options.WAL {
+		err = w.commitWAL()
+	} else {
+		err = w.commitJournal()
+	}
+	if err != nil {
+		return err
+	}
+
+	cnt := make([]byte, w.header.pageSize*w.dbSize)
+	copy(cnt, w.cnt)
+	for pageNum, data := range w.modified {
+		copy(cnt[w.header.pageSize*(pageNum-1):], data)
+	}
+	w.cnt = cnt
+	w.modified = map[int][]byte{}
+	return nil
+}
+
+func (w *Writer) modifiedPages() []int {
+	pageNums := []int{}
+	for pageNum := range w.modified {
+		pageNums = append(pageNums, pageNum)
+	}
+	sort.Ints(pageNums)
+	return pageNums
+}
+
+// commitJournal saves the original pages to <db>-journal, writes the
+// database file and deletes the journal. If the process dies in between,
+// sqlite3 rolls back the hot journal the next time it opens the file.
+func (w *Writer) commitJournal() error {
+	pageSize := w.header.pageSize
+	origSize := len(w.cnt) / pageSize
+
+	// The change counter tells other connections that the file changed.
+	page1, err := w.Page(1)
+	if err != nil {
+		return err
+	}
+	w.modified[1] = updateFileHeader(page1, w.dbSize)
+
+	nonce := rand.Uint32()
+	journal := make([]byte, journalSectorSize)
+	copy(journal, journalMagic)
+	binary.BigEndian.PutUint32(journal[12:], nonce)
+	binary.BigEndian.PutUint32(journal[16:], uint32(origSize))
+	binary.BigEndian.PutUint32(journal[20:], journalSectorSize)
+	binary.BigEndian.PutUint32(journal[24:], uint32(pageSize))
+
+	nRec := 0
+	for _, pageNum := range w.modifiedPages() {
+		if pageNum > origSize {
+			continue
+		}
+		orig := fetch(w.cnt, pageSize*(pageNum-1), pageSize)
+
+		record := make([]byte, 4+pageSize+4)
+		binary.BigEndian.PutUint32(record, uint32(pageNum))
+		copy(record[4:], orig)
+		binary.BigEndian.PutUint32(record[4+pageSize:], journalChecksum(nonce, orig))
+		journal = append(journal, record...)
+		nRec++
+	}
+	binary.BigEndian.PutUint32(journal[8:], uint32(nRec))
+
+	journalPath := w.Path + "-journal"
+	if err := writeFileSync(journalPath, journal); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(w.Path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, pageNum := range w.modifiedPages() {
+		if _, err := file.WriteAt(w.modified[pageNum], int64(pageSize*(pageNum-1))); err != nil {
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	return os.Remove(journalPath)
+}
+
+// sqlite3/src/pager.c:pager_cksum
+func journalChecksum(nonce uint32, page []byte) uint32 {
+	cksum := nonce
+	for i := len(page) - 200; i > 0; i -= 200 {
+		cksum += uint32(page[i])
+	}
+	return cksum
+}
+
+// commitWAL appends the pending pages to <db>-wal, the last one marked
+// as the commit frame. Frames after the last valid commit are dropped.
+func (w *Writer) commitWAL() error {
+	pageSize := w.header.pageSize
+
+	// As in commitJournal, page 1 carries the new size of the database.
+	page1, err := w.Page(1)
+	if err != nil {
+		return err
+	}
+	w.modified[1] = updateFileHeader(page1, w.dbSize)
+
+	file, err := os.OpenFile(w.Path+"-wal", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var header *WALHeader
+	offset := walHeaderSize
+	if w.wal != nil && w.wal.Header != nil && w.wal.Header.pageSize == pageSize {
+		header = w.wal.Header
+		if w.wal.mxFrame > 0 {
+			offset = w.wal.Frames[w.wal.mxFrame-1].offset + walFrameHeaderSize + pageSize
+		}
+	} else {
+		header = &WALHeader{
+			magic:    walMagic,
+			version:  walVersion,
+			pageSize: pageSize,
+			salt1:    rand.Uint32(),
+			salt2:    rand.Uint32(),
+		}
+		if _, err := file.WriteAt(header.bytes(), 0); err != nil {
+			return err
+		}
+	}
+
+	s1, s2 := header.checksum1, header.checksum2
+	if w.wal != nil && w.wal.Header == header && w.wal.mxFrame > 0 {
+		last := w.wal.Frames[w.wal.mxFrame-1]
+		s1, s2 = last.checksum1, last.checksum2
+	}
+
+	buf := []byte{}
+	frames := []*WALFrame{}
+	pageNums := w.modifiedPages()
+	for i, pageNum := range pageNums {
+		frame := &WALFrame{
+			pageNum: pageNum,
+			salt1:   header.salt1,
+			salt2:   header.salt2,
+			offset:  offset + len(buf),
+			data:    w.modified[pageNum],
+		}
+		if i == len(pageNums)-1 {
+			frame.dbSize = w.dbSize
+		}
+
+		bytes := make([]byte, walFrameHeaderSize, walFrameHeaderSize+pageSize)
+		binary.BigEndian.PutUint32(bytes[0:], uint32(frame.pageNum))
+		binary.BigEndian.PutUint32(bytes[4:], uint32(frame.dbSize))
+		binary.BigEndian.PutUint32(bytes[8:], frame.salt1)
+		binary.BigEndian.PutUint32(bytes[12:], frame.salt2)
+		s1, s2 = walChecksum(header.bigEndian(), bytes[0:8], s1, s2)
+		s1, s2 = walChecksum(header.bigEndian(), frame.data, s1, s2)
+		frame.checksum1, frame.checksum2 = s1, s2
+		binary.BigEndian.PutUint32(bytes[16:], s1)
+		binary.BigEndian.PutUint32(bytes[20:], s2)
+
+		buf = append(append(buf, bytes...), frame.data...)
+		frames = append(frames, frame)
+	}
+
+	if _, err := file.WriteAt(buf, int64(offset)); err != nil {
+		return err
+	}
+	if err := file.Truncate(int64(offset + len(buf))); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	if w.wal == nil || w.wal.Header != header {
+		w.wal = &WAL{Path: w.Path + "-wal", Header: header, Frames: []*WALFrame{}}
+	}
+	w.wal.Frames = append(w.wal.Frames[:w.wal.mxFrame], frames...)
+	w.wal.mxFrame = len(w.wal.Frames)
+	return nil
+}
+
+func writeFileSync(path string, bytes []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(bytes); err != nil {
+		return err
+	}
+	return file.Sync()
+}
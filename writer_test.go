@@ -0,0 +1,121 @@
+package sqlite3utils
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// replaceInPage overwrites the first occurrence of old in the page.
+func replaceInPage(t *testing.T, w *Writer, pageNum int, old, new string) {
+	data, err := w.Page(pageNum)
+	assert.Nil(t, err)
+	i := bytes.Index(data, []byte(old))
+	if i < 0 {
+		t.Fatal("not found:", old)
+	}
+	copy(data[i:], new)
+	assert.Nil(t, w.WritePage(pageNum, data))
+}
+
+func TestWriterJournal(t *testing.T) {
+	filename := "/tmp/test_writer.db"
+	rmSQLite(filename)
+
+	execSQLite(filename, []string{
+		"CREATE TABLE person(id integer, name text);",
+		"INSERT INTO person VALUES (1, \"hoge\");",
+	})
+
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	counter := storage.Header.changeCounter
+
+	w, err := OpenWriter(filename, WriterOptions{})
+	assert.Nil(t, err)
+	replaceInPage(t, w, 2, "hoge", "fuga")
+	assert.Nil(t, w.Commit())
+
+	storage, err = Load(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "fuga", storage.Tables["person"].Entries[0].Datas[1].Value)
+	assert.Equal(t, counter+1, storage.Header.changeCounter)
+
+	rmSQLite(filename)
+}
+
+func TestWriterWAL(t *testing.T) {
+	filename := "/tmp/test_writer_wal.db"
+	rmSQLite(filename)
+	rmSQLite(filename + "-wal")
+	rmSQLite(filename + "-shm")
+
+	execSQLite(filename, []string{
+		"PRAGMA journal_mode=WAL;",
+		"CREATE TABLE person(id integer, name text);",
+		noCheckpoint + "INSERT INTO person VALUES (1, \"hoge\");",
+	})
+
+	_, err := OpenWriter(filename, WriterOptions{})
+	assert.NotNil(t, err)
+
+	w, err := OpenWriter(filename, WriterOptions{WAL: true})
+	assert.Nil(t, err)
+	replaceInPage(t, w, 2, "hoge", "fuga")
+	assert.Nil(t, w.Commit())
+	replaceInPage(t, w, 2, "fuga", "piyo")
+	assert.Nil(t, w.Commit())
+
+	commits, err := Commits(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(commits))
+
+	storage, err := LoadCommit(filename, commits[1].Frame)
+	assert.Nil(t, err)
+	assert.Equal(t, "fuga", storage.Tables["person"].Entries[0].Datas[1].Value)
+
+	storage, err = Load(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "piyo", storage.Tables["person"].Entries[0].Datas[1].Value)
+
+	rmSQLite(filename)
+	rmSQLite(filename + "-wal")
+	rmSQLite(filename + "-shm")
+}
+
+func TestWriterWALGrow(t *testing.T) {
+	filename := "/tmp/test_writer_wal_grow.db"
+	rmSQLite(filename)
+	rmSQLite(filename + "-wal")
+	rmSQLite(filename + "-shm")
+
+	execSQLite(filename, []string{
+		"PRAGMA journal_mode=WAL; PRAGMA page_size = 512;",
+		noCheckpoint + "CREATE TABLE person(id integer primary key, name text);",
+	})
+	storage, err := Load(filename)
+	assert.Nil(t, err)
+	pages := len(storage.Pages)
+
+	in := &bytes.Buffer{}
+	in.WriteString("id,name\n")
+	for i := 1; i <= 3000; i++ {
+		fmt.Fprintf(in, "%d,person-%d\n", i, i)
+	}
+	result, err := ImportCSV(filename, "person", in, ImportOptions{BatchSize: 1000, Writer: WriterOptions{WAL: true}})
+	assert.Nil(t, err)
+	assert.Equal(t, 3000, result.Inserted)
+
+	storage, err = Load(filename)
+	assert.Nil(t, err)
+	assert.True(t, len(storage.Pages) > pages)
+	assert.Equal(t, len(storage.Pages), storage.Header.inHeaderDbSize)
+	assert.Empty(t, Check(storage))
+	assert.Equal(t, "ok", integrityCheck(t, filename))
+
+	rmSQLite(filename)
+	rmSQLite(filename + "-wal")
+	rmSQLite(filename + "-shm")
+}